@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Zeeno-atl/all-build/internal/cache"
+)
+
+// cacheCommand handles "compiler cache stats" and "compiler cache clear".
+func cacheCommand(localCache *cache.Local, command string) int {
+	switch command {
+	case "stats":
+		stats := localCache.Stats()
+		ratio := 0.0
+		if stats.Hits+stats.Misses > 0 {
+			ratio = float64(stats.Hits) / float64(stats.Hits+stats.Misses) * 100
+		}
+		fmt.Printf("hits:    %d (%.1f %%)\n", stats.Hits, ratio)
+		fmt.Printf("misses:  %d\n", stats.Misses)
+		fmt.Printf("entries: %d\n", stats.Entries)
+		fmt.Printf("size:    %.1f MiB\n", float64(stats.Bytes)/(1<<20))
+		return 0
+	case "clear":
+		if err := localCache.Clear(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown cache command: %s (expected stats or clear)\n", command)
+	return 2
+}
@@ -7,6 +7,8 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/Zeeno-atl/all-build/internal/utils"
+	"github.com/Zeeno-atl/all-build/pkg/compiler"
 	"gopkg.in/yaml.v3"
 )
 
@@ -14,8 +16,23 @@ type Config struct {
 	TaskDatabase *string `yaml:"task-database"`
 	Tag          string  `yaml:"tag"`
 	CompilerType string  `yaml:"compiler"`
+	Dependencies *string `yaml:"dependencies"` // "scan" follows includes, "walk" ships whole directories, "preprocess" and "directives" preprocess locally
+	CacheDir     *string `yaml:"cache-dir"`
+	CacheSize    *int    `yaml:"cache-size"` // MiB, 0 disables the cache
+
+	Timeout       *int    `yaml:"timeout"`        // seconds, then the file is compiled locally
+	LocalCompiler *string `yaml:"local-compiler"` // run when the file cannot be compiled remotely
+
+	RemoteCache         *string `yaml:"remote-cache"`           // off, read-only or read-write
+	RemoteCacheTTL      *int    `yaml:"remote-cache-ttl"`       // hours
+	RemoteCacheMaxEntry *int    `yaml:"remote-cache-max-entry"` // MiB
+
+	Rules []Rule `yaml:"rules"` // force invocations to run locally or remotely
 }
 
+// dependencyModes are the values of dependencies
+var dependencyModes = []string{"scan", "walk", "preprocess", "directives"}
+
 func toType[T any](value string) T {
 	var result T
 	fmt.Sscanf(value, "%v", &result)
@@ -57,6 +74,28 @@ func loadValue[T any](config **T, name string, description string, value T) {
 	}
 }
 
+// defaultLocalCompiler is the driver of the language of the invocation, as a
+// C source compiled by a C++ driver is compiled as C++. Links and unknown
+// languages use the C++ driver, which links both.
+func defaultLocalCompiler(compilerType string, language string) string {
+	c := utils.Contains([]string{"c", "c-header", "cpp-output", "objective-c", "objective-c-header", "assembler", "assembler-with-cpp"}, language)
+	switch compilerType {
+	case compiler.MSVCCompiler:
+		return "cl"
+	case compiler.ClangCLCompiler:
+		return "clang-cl"
+	case compiler.ClangCompiler:
+		if c {
+			return "clang"
+		}
+		return "clang++"
+	}
+	if c {
+		return "gcc"
+	}
+	return "g++"
+}
+
 func LoadConfig() (Config, error) {
 	var config Config
 
@@ -81,6 +120,30 @@ func LoadConfig() (Config, error) {
 	}
 
 	loadValue(&config.TaskDatabase, "task-database", "Task database", "127.0.0.1:6379")
+	loadValue(&config.Dependencies, "dependencies", "Dependency detection, scan, walk, preprocess or directives", "scan")
+	loadValue(&config.Timeout, "timeout", "Deadline of a remote compilation in seconds", 600)
+	// the default depends on the language of the invocation, see defaultLocalCompiler
+	loadValue(&config.LocalCompiler, "local-compiler", "Compiler used when compiling locally", "")
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	loadValue(&config.CacheDir, "cache-dir", "Directory of the local result cache", filepath.Join(cacheDir, "all-build"))
+	loadValue(&config.CacheSize, "cache-size", "Size of the local result cache in MiB", 5120)
+	loadValue(&config.RemoteCache, "remote-cache", "Shared result cache, off, read-only or read-write", "read-only")
+	loadValue(&config.RemoteCacheTTL, "remote-cache-ttl", "Lifetime of the shared results in hours", 7*24)
+	loadValue(&config.RemoteCacheMaxEntry, "remote-cache-max-entry", "Largest shared result in MiB", 64)
+
+	if !utils.Contains(dependencyModes, *config.Dependencies) {
+		return config, fmt.Errorf("unknown dependencies: %s, expected one of %s", *config.Dependencies, strings.Join(dependencyModes, ", "))
+	}
+
+	for i := range config.Rules {
+		if err := config.Rules[i].compile(); err != nil {
+			return config, fmt.Errorf("rule %d: %v", i+1, err)
+		}
+	}
 
 	return config, nil
 }
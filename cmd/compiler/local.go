@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// compileLocally runs the real compiler on this machine and exits with its return code.
+// When the reason is an interruption by the user, it exits right away.
+func compileLocally(ctx context.Context, executable string, args []string, reason error) {
+	if ctx.Err() != nil {
+		log.Printf("interrupted")
+		os.Exit(130)
+	}
+	log.Printf("compiling locally: %v", reason)
+	runCompiler(executable, args)
+}
+
+// runCompiler runs the real compiler with the standard streams of the client
+// and exits with its return code.
+func runCompiler(executable string, args []string) {
+	cmd := exec.Command(executable, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	var exitError *exec.ExitError
+	if errors.As(err, &exitError) {
+		os.Exit(exitError.ExitCode())
+	}
+	if err != nil {
+		log.Fatalf("could not run %s: %v", executable, err)
+	}
+	os.Exit(0)
+}
@@ -1,74 +1,224 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/Zeeno-atl/all-build/internal/blobs"
+	"github.com/Zeeno-atl/all-build/internal/cache"
 	"github.com/Zeeno-atl/all-build/internal/tasks"
+	"github.com/Zeeno-atl/all-build/pkg/compiler"
 	"github.com/hibiken/asynq"
+	"github.com/redis/go-redis/v9"
 )
 
 const (
 	Version = "0.2.0"
 )
 
+func writeResult(result *tasks.Response) {
+	fmt.Fprint(os.Stderr, result.Stderr)
+	fmt.Fprint(os.Stdout, result.Stdout)
+
+	for _, file := range result.Files {
+		if file.Tree {
+			if err := tasks.WriteTree(file.Path, file.Content, os.FileMode(file.Chmod)); err != nil {
+				log.Printf("could not write directory: %v", err)
+			}
+			continue
+		}
+
+		if err := tasks.WriteFile(file.Path, file); err != nil {
+			log.Printf("could not write %s: %v", file.Path, err)
+		}
+	}
+}
+
 func main() {
 	config, err := LoadConfig()
 	if err != nil {
 		log.Fatalln("Error loading configuration:", err)
 	}
 
+	var localCache *cache.Local
+	if *config.CacheSize > 0 {
+		localCache, err = cache.NewLocal(*config.CacheDir, int64(*config.CacheSize)<<20)
+		if err != nil {
+			log.Printf("could not open cache: %v", err)
+		}
+	}
+
+	if len(os.Args) == 3 && os.Args[1] == "cache" {
+		if localCache == nil {
+			log.Fatalln("cache is disabled")
+		}
+		os.Exit(cacheCommand(localCache, os.Args[2]))
+	}
+
 	client := asynq.NewClient(asynq.RedisClientOpt{Addr: *config.TaskDatabase})
 	defer client.Close()
 
-	task, err := tasks.NewCompileFile(os.Args[1:], config.Tag, config.CompilerType)
+	args := os.Args[1:]
+	timeout := time.Duration(*config.Timeout) * time.Second
+
+	interrupted, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// queries, links and the like run locally without a word, as if there were no distribution
+	remoteArgs, preprocessedFile := args, ""
+	c := compiler.NewCompiler(config.CompilerType)
+	parsed := c != nil && c.Parse(args) == nil
+	if *config.LocalCompiler == "" {
+		language := ""
+		if parsed {
+			language = c.Invocation().Language
+		}
+		*config.LocalCompiler = defaultLocalCompiler(config.CompilerType, language)
+	}
+	if parsed {
+		if runsLocally(config.Rules, c.Invocation(), args) {
+			runCompiler(*config.LocalCompiler, args)
+		}
+
+		// only the preprocessed source is sent, the headers are not needed
+		if *config.Dependencies == "preprocess" || *config.Dependencies == "directives" {
+			preprocessed, path, err := preprocessLocally(c, *config.LocalCompiler, *config.Dependencies == "directives")
+			if err != nil {
+				log.Printf("could not preprocess locally, scanning the includes: %v", err)
+			} else {
+				remoteArgs, preprocessedFile = preprocessed, path
+			}
+		}
+	}
+
+	compileFile, err := tasks.NewCompileFile(remoteArgs, config.Tag, config.CompilerType, *config.Dependencies != "walk")
+	if preprocessedFile != "" {
+		// the task holds the content already
+		os.Remove(preprocessedFile)
+	}
 	if err != nil {
-		log.Fatalf("could not create task: %v", err)
+		compileLocally(interrupted, *config.LocalCompiler, args, fmt.Errorf("could not create task: %v", err))
+	}
+
+	redisClient := redis.NewClient(&redis.Options{Addr: *config.TaskDatabase})
+
+	// the results of another version of the compiler are not reused
+	fingerprint, toolErr := redisClient.Get(interrupted, tasks.ToolKey(config.Tag)).Result()
+	if toolErr == redis.Nil {
+		toolErr = nil
 	}
+	if toolErr == nil && localCache != nil {
+		if err := localCache.PutTool(config.Tag, fingerprint); err != nil {
+			log.Printf("could not store the tool in cache: %v", err)
+		}
+	}
+	if toolErr != nil {
+		// the results cached locally are still found with the tool seen last
+		stored, ok := "", false
+		if localCache != nil {
+			stored, ok = localCache.Tool(config.Tag)
+		}
+		if !ok {
+			compileLocally(interrupted, *config.LocalCompiler, args, fmt.Errorf("could not get the tool of '%s': %v", config.Tag, toolErr))
+		}
+		fingerprint = stored
+	}
+	compileFile.Tool = fingerprint
 
-	info, err := client.Enqueue(task, asynq.Queue(config.Tag), asynq.Retention(time.Minute*2))
+	actionHash := compileFile.ActionHash()
+	if localCache != nil {
+		if result, ok := localCache.Get(actionHash); ok {
+			writeResult(result)
+			os.Exit(result.ReturnCode)
+		}
+	}
+	if toolErr != nil {
+		compileLocally(interrupted, *config.LocalCompiler, args, fmt.Errorf("could not get the tool of '%s': %v", config.Tag, toolErr))
+	}
+
+	remoteCache, err := cache.NewRemote(redisClient, *config.RemoteCache, time.Duration(*config.RemoteCacheTTL)*time.Hour, *config.RemoteCacheMaxEntry<<20)
 	if err != nil {
-		log.Fatalf("could not enqueue task: %v", err)
+		log.Fatalln("Error loading configuration:", err)
+	}
+	if result, ok, err := remoteCache.Get(interrupted, actionHash); err != nil {
+		log.Printf("could not query remote cache: %v", err)
+	} else if ok {
+		if localCache != nil {
+			if err := localCache.Put(actionHash, result); err != nil {
+				log.Printf("could not store result in cache: %v", err)
+			}
+		}
+		writeResult(result)
+		os.Exit(result.ReturnCode)
 	}
 
-	// loop until the task is finished
 	inspector := asynq.NewInspector(asynq.RedisClientOpt{Addr: *config.TaskDatabase})
 
-	for {
-		status, err := inspector.GetTaskInfo(info.Queue, info.ID)
+	// the task ID is the action hash, so identical compilations running at the
+	// same time (e.g. by two developers) are compiled only once
+	enqueued := false
+	if status, err := inspector.GetTaskInfo(config.Tag, actionHash); err == nil && status.State == asynq.TaskStateArchived {
+		// a failed compilation is tried again
+		inspector.DeleteTask(config.Tag, actionHash)
+	}
+	if _, err := inspector.GetTaskInfo(config.Tag, actionHash); err != nil {
+		if ok, err := hasWorker(inspector, config.Tag); err != nil {
+			compileLocally(interrupted, *config.LocalCompiler, args, err)
+		} else if !ok {
+			compileLocally(interrupted, *config.LocalCompiler, args, fmt.Errorf("no executor serves queue '%s'", config.Tag))
+		}
+
+		store := blobs.NewRedisStore(redisClient, blobs.DefaultTTL)
+		if err := compileFile.Upload(interrupted, store); err != nil {
+			log.Printf("could not upload inputs, sending them inline: %v", err)
+		}
+
+		task, err := compileFile.Task()
+		if interrupted.Err() != nil {
+			compileLocally(interrupted, *config.LocalCompiler, args, interrupted.Err())
+		}
 		if err != nil {
-			log.Fatalf("could not get task status: %v", err)
-			break
+			compileLocally(interrupted, *config.LocalCompiler, args, fmt.Errorf("could not create task: %v", err))
 		}
-		if status.State == asynq.TaskStateCompleted {
-			var result tasks.Response
-			err := json.Unmarshal([]byte(status.Result), &result)
-			if err != nil {
-				log.Fatalf("could not unmarshal result: %v", err)
-			}
 
-			fmt.Fprint(os.Stderr, result.Stderr)
-			fmt.Fprint(os.Stdout, result.Stdout)
+		// a failed task is archived right away instead of retrying, the client compiles it locally
+		// a conflict means another client enqueued the same action in the meantime
+		_, err = client.Enqueue(task,
+			asynq.Queue(config.Tag),
+			asynq.TaskID(actionHash),
+			asynq.MaxRetry(0),
+			asynq.Timeout(timeout),
+			asynq.Retention(time.Minute*2))
+		if err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+			compileLocally(interrupted, *config.LocalCompiler, args, fmt.Errorf("could not enqueue task: %v", err))
+		}
+		enqueued = err == nil
+	}
 
-			for _, file := range result.Files {
-				err = os.WriteFile(file.Path, file.Content, 0644)
-				if err != nil {
-					log.Printf("could not write file: %v", err)
-				}
+	ctx, cancel := context.WithTimeout(interrupted, timeout)
+	defer cancel()
 
-				err = os.Chmod(file.Path, os.FileMode(file.Chmod))
-				if err != nil {
-					log.Printf("could not chmod file: %v", err)
-				}
-			}
+	result, err := waitForResult(ctx, inspector, redisClient, config.Tag, actionHash)
+	if err != nil {
+		releaseTask(inspector, redisClient, config.Tag, actionHash, enqueued)
+		compileLocally(interrupted, *config.LocalCompiler, args, err)
+	}
 
-			os.Exit(result.ReturnCode)
+	if localCache != nil {
+		if err := localCache.Put(actionHash, result); err != nil {
+			log.Printf("could not store result in cache: %v", err)
 		}
-
-		time.Sleep(10 * time.Millisecond)
+	}
+	if err := remoteCache.Put(context.Background(), actionHash, result); err != nil {
+		log.Printf("could not store result in remote cache: %v", err)
 	}
 
+	writeResult(result)
+	os.Exit(result.ReturnCode)
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Zeeno-atl/all-build/pkg/compiler"
+)
+
+// preprocessedExtensions are the extensions of the preprocessed files by the source language
+var preprocessedExtensions = map[string]string{
+	"c":             ".i",
+	"objective-c":   ".mi",
+	"objective-c++": ".mii",
+}
+
+// preprocessedPath names the preprocessed file after the output, so the command
+// sent to the executor, and with it the action hash, does not change between builds.
+func preprocessedPath(invocation compiler.Invocation, directivesOnly bool) string {
+	extension, ok := preprocessedExtensions[invocation.Language]
+	if !ok {
+		extension = ".ii"
+	}
+	if directivesOnly {
+		extension = ".directives" + extension
+	}
+
+	output := invocation.Output
+	if output == "" {
+		output = filepath.Base(invocation.Sources[0])
+	}
+	return output + extension
+}
+
+// preprocessLocally runs the preprocessor on this machine and returns the command
+// compiling the preprocessed file and its path. The diagnostics of the preprocessor
+// are shown right away, a failing preprocessor ends the client with its return code.
+func preprocessLocally(c compiler.ICompiler, executable string, directivesOnly bool) ([]string, string, error) {
+	invocation := c.Invocation()
+	path := preprocessedPath(invocation, directivesOnly)
+
+	local, remote, err := c.Preprocess(path, directivesOnly)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, "", fmt.Errorf("could not create directory: %v", err)
+	}
+
+	cmd := exec.Command(executable, local...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(path)
+		var exitError *exec.ExitError
+		if errors.As(err, &exitError) {
+			os.Exit(exitError.ExitCode())
+		}
+		return nil, "", fmt.Errorf("could not run %s %s: %v", executable, strings.Join(local, " "), err)
+	}
+
+	return remote, path, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/Zeeno-atl/all-build/internal/utils"
+	"github.com/Zeeno-atl/all-build/pkg/compiler"
+)
+
+const (
+	RunLocal  = "local"
+	RunRemote = "remote"
+)
+
+// Rule forces an invocation to run locally or remotely. Every criterion which
+// is set must match, the first matching rule wins.
+type Rule struct {
+	Mode     string `yaml:"mode"`     // compile, assemble, preprocess, dependencies, syntax, link or query
+	Language string `yaml:"language"` // e.g. c, c++ or assembler
+	Argument string `yaml:"argument"` // regular expression matching any argument
+	Source   string `yaml:"source"`   // regular expression matching any source
+	Run      string `yaml:"run"`      // local or remote
+
+	argument *regexp.Regexp
+	source   *regexp.Regexp
+}
+
+func (r *Rule) compile() error {
+	if r.Run != RunLocal && r.Run != RunRemote {
+		return fmt.Errorf("rule must run 'local' or 'remote', not '%s'", r.Run)
+	}
+
+	var err error
+	if r.Argument != "" {
+		if r.argument, err = regexp.Compile(r.Argument); err != nil {
+			return fmt.Errorf("invalid argument pattern: %v", err)
+		}
+	}
+	if r.Source != "" {
+		if r.source, err = regexp.Compile(r.Source); err != nil {
+			return fmt.Errorf("invalid source pattern: %v", err)
+		}
+	}
+	return nil
+}
+
+func (r *Rule) matches(invocation compiler.Invocation, args []string) bool {
+	switch {
+	case r.Mode != "" && r.Mode != invocation.Mode:
+		return false
+	case r.Language != "" && r.Language != invocation.Language:
+		return false
+	case r.argument != nil && !utils.ContainsIf(args, r.argument.MatchString):
+		return false
+	case r.source != nil && !utils.ContainsIf(invocation.Sources, r.source.MatchString):
+		return false
+	}
+	return true
+}
+
+// runsLocally decides where the invocation runs. Without a matching rule, the
+// invocations which cannot be distributed run locally.
+func runsLocally(rules []Rule, invocation compiler.Invocation, args []string) bool {
+	for _, rule := range rules {
+		if rule.matches(invocation, args) {
+			return rule.Run == RunLocal
+		}
+	}
+	return !invocation.Distributable
+}
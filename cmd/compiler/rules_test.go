@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Zeeno-atl/all-build/pkg/compiler"
+)
+
+func TestRuleCompile(t *testing.T) {
+	cases := []struct {
+		name  string
+		rule  Rule
+		fails bool
+	}{
+		{"local", Rule{Run: RunLocal}, false},
+		{"remote with patterns", Rule{Run: RunRemote, Argument: "^-g", Source: `\.cpp$`}, false},
+		{"missing run", Rule{Mode: "compile"}, true},
+		{"unknown run", Rule{Run: "elsewhere"}, true},
+		{"invalid argument", Rule{Run: RunLocal, Argument: "("}, true},
+		{"invalid source", Rule{Run: RunLocal, Source: "[a-"}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.rule.compile(); (err != nil) != tc.fails {
+				t.Errorf("Expected failure %v, got %v", tc.fails, err)
+			}
+		})
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	invocation := compiler.Invocation{Mode: "compile", Language: "c++", Sources: []string{"src/generated/big.cpp"}, Distributable: true}
+	args := []string{"-c", "-O2", "src/generated/big.cpp", "-o", "big.o"}
+
+	cases := []struct {
+		name    string
+		rule    Rule
+		matches bool
+	}{
+		{"empty", Rule{}, true},
+		{"mode", Rule{Mode: "compile"}, true},
+		{"other mode", Rule{Mode: "preprocess"}, false},
+		{"language", Rule{Language: "c++"}, true},
+		{"other language", Rule{Language: "c"}, false},
+		{"argument", Rule{Argument: "^-O[23]$"}, true},
+		{"other argument", Rule{Argument: "^-g$"}, false},
+		{"source", Rule{Source: "/generated/"}, true},
+		{"other source", Rule{Source: `\.c$`}, false},
+		{"all criteria", Rule{Mode: "compile", Language: "c++", Argument: "^-O2$", Source: "big"}, true},
+		{"one criterion fails", Rule{Mode: "compile", Language: "c++", Argument: "^-O2$", Source: "small"}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.rule.Run = RunLocal
+			if err := tc.rule.compile(); err != nil {
+				t.Fatal(err)
+			}
+			if matches := tc.rule.matches(invocation, args); matches != tc.matches {
+				t.Errorf("Expected match %v, got %v", tc.matches, matches)
+			}
+		})
+	}
+}
+
+func TestRunsLocally(t *testing.T) {
+	compile := compiler.Invocation{Mode: "compile", Language: "c", Sources: []string{"a.c"}, Distributable: true}
+	link := compiler.Invocation{Mode: "link", Sources: []string{"a.o"}}
+	args := []string{"-c", "a.c"}
+
+	cases := []struct {
+		name       string
+		rules      []Rule
+		invocation compiler.Invocation
+		local      bool
+	}{
+		{"distributable without rules", nil, compile, false},
+		{"not distributable without rules", nil, link, true},
+		{"forced local", []Rule{{Mode: "compile", Run: RunLocal}}, compile, true},
+		{"forced remote", []Rule{{Mode: "link", Run: RunRemote}}, link, false},
+		{"no rule matches", []Rule{{Mode: "preprocess", Run: RunLocal}}, compile, false},
+		{"first rule wins", []Rule{{Source: `\.c$`, Run: RunLocal}, {Mode: "compile", Run: RunRemote}}, compile, true},
+		{"later rule matches", []Rule{{Language: "c++", Run: RunRemote}, {Mode: "compile", Run: RunLocal}}, compile, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for i := range tc.rules {
+				if err := tc.rules[i].compile(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if local := runsLocally(tc.rules, tc.invocation, args); local != tc.local {
+				t.Errorf("Expected local %v, got %v", tc.local, local)
+			}
+		})
+	}
+}
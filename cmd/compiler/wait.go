@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Zeeno-atl/all-build/internal/tasks"
+	"github.com/hibiken/asynq"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// the task state is still checked periodically, in case a notification is lost
+	fallbackPollInterval = time.Second
+	// used when the notifications are not available at all
+	pollInterval = 100 * time.Millisecond
+	// how long a cancelled task may take to stop on the executor
+	cancelTimeout = 5 * time.Second
+)
+
+func parseResult(data []byte) (*tasks.Response, error) {
+	var result tasks.Response
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("could not unmarshal result: %v", err)
+	}
+	if result.Error != "" {
+		return nil, fmt.Errorf("task failed on the executor: %s", result.Error)
+	}
+	return &result, nil
+}
+
+// hasWorker tells whether any executor serves the queue.
+func hasWorker(inspector *asynq.Inspector, queue string) (bool, error) {
+	servers, err := inspector.Servers()
+	if err != nil {
+		return false, fmt.Errorf("could not list executors: %v", err)
+	}
+	for _, server := range servers {
+		if _, ok := server.Queues[queue]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkTask returns the result of a completed task, or an error when the task
+// will not complete.
+func checkTask(inspector *asynq.Inspector, queue string, id string) (*tasks.Response, error) {
+	status, err := inspector.GetTaskInfo(queue, id)
+	if errors.Is(err, asynq.ErrTaskNotFound) || errors.Is(err, asynq.ErrQueueNotFound) {
+		return nil, fmt.Errorf("task %s disappeared from queue '%s'", id, queue)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get task status: %v", err)
+	}
+
+	switch status.State {
+	case asynq.TaskStateCompleted:
+		return parseResult(status.Result)
+	case asynq.TaskStateRetry:
+		return nil, fmt.Errorf("task %s failed on the executor: %s", id, status.LastErr)
+	case asynq.TaskStateArchived:
+		return nil, fmt.Errorf("task %s was archived: %s", id, status.LastErr)
+	case asynq.TaskStatePending, asynq.TaskStateScheduled:
+		ok, err := hasWorker(inspector, queue)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("no executor serves queue '%s'", queue)
+		}
+	}
+	return nil, nil
+}
+
+// releaseTask gives up on a task. A failed task is removed, so the next build
+// of the file does not find it under the same ID. The task ID is the action
+// hash, other clients may wait for the same task, so a task still to be
+// compiled is only cancelled when this client enqueued it and nobody else
+// waits for its result.
+func releaseTask(inspector *asynq.Inspector, redisClient redis.UniversalClient, queue string, id string, enqueued bool) {
+	status, err := inspector.GetTaskInfo(queue, id)
+	if err != nil {
+		return
+	}
+	if status.State == asynq.TaskStateArchived {
+		if err := inspector.DeleteTask(queue, id); err != nil {
+			log.Printf("could not remove task %s: %v", id, err)
+		}
+		return
+	}
+	if !enqueued {
+		return
+	}
+
+	// every waiting client subscribes to the completion channel
+	channel := tasks.CompletionChannel(id)
+	waiting, err := redisClient.PubSubNumSub(context.Background(), channel).Result()
+	if err != nil || waiting[channel] > 0 {
+		return
+	}
+	cancelTask(inspector, queue, id)
+}
+
+// cancelTask stops the task on the executor, or removes it when no executor
+// took it yet. A cancelled task is archived by the executor, it is removed too,
+// so the next build of the file does not find it under the same ID.
+func cancelTask(inspector *asynq.Inspector, queue string, id string) {
+	status, err := inspector.GetTaskInfo(queue, id)
+	if err != nil {
+		return
+	}
+
+	switch status.State {
+	case asynq.TaskStateActive:
+		if err = inspector.CancelProcessing(id); err == nil {
+			err = deleteWhenArchived(inspector, queue, id)
+		}
+	case asynq.TaskStatePending, asynq.TaskStateScheduled, asynq.TaskStateRetry, asynq.TaskStateArchived:
+		err = inspector.DeleteTask(queue, id)
+	}
+	if err != nil {
+		log.Printf("could not cancel task %s: %v", id, err)
+	}
+}
+
+// deleteWhenArchived waits for the executor to stop a cancelled task and removes it.
+func deleteWhenArchived(inspector *asynq.Inspector, queue string, id string) error {
+	deadline := time.Now().Add(cancelTimeout)
+	for time.Now().Before(deadline) {
+		status, err := inspector.GetTaskInfo(queue, id)
+		if errors.Is(err, asynq.ErrTaskNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if status.State != asynq.TaskStateActive {
+			return inspector.DeleteTask(queue, id)
+		}
+		time.Sleep(pollInterval)
+	}
+	return fmt.Errorf("task %s is still running", id)
+}
+
+// waitForResult blocks until the executor publishes the result of the task.
+// Any error means the task will not be compiled remotely in time.
+func waitForResult(ctx context.Context, inspector *asynq.Inspector, redisClient redis.UniversalClient, queue string, id string) (*tasks.Response, error) {
+	interval := fallbackPollInterval
+
+	// subscribe before the first check, so the notification cannot be missed in between
+	subscription := redisClient.Subscribe(ctx, tasks.CompletionChannel(id))
+	defer subscription.Close()
+
+	var notifications <-chan *redis.Message
+	if _, err := subscription.Receive(ctx); err != nil {
+		interval = pollInterval
+	} else {
+		notifications = subscription.Channel()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		result, err := checkTask(inspector, queue, id)
+		if result != nil || err != nil {
+			return result, err
+		}
+
+		select {
+		case message, ok := <-notifications:
+			if ok {
+				return parseResult([]byte(message.Payload))
+			}
+			notifications = nil
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("task %s did not finish in time: %v", id, ctx.Err())
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 
@@ -13,9 +14,16 @@ import (
 )
 
 type Config struct {
-	TaskDatabase *string         `yaml:"task-database"`
-	Concurrency  *int            `yaml:"concurrency"`
-	Tools        []executor.Tool `yaml:"tools"`
+	TaskDatabase  *string         `yaml:"task-database"`
+	Concurrency   *int            `yaml:"concurrency"`
+	BlobCache     *string         `yaml:"blob-cache"`
+	BlobCacheSize *int            `yaml:"blob-cache-size"` // MiB
+	Workspaces    *string         `yaml:"workspaces"`
+	Quota         *int            `yaml:"workspace-quota"` // MiB, 0 is unlimited
+	KeepFailed    *int            `yaml:"keep-failed"`     // minutes, 0 removes failed workspaces right away
+	Sandbox       *string         `yaml:"sandbox"`         // chroot, prefix-map or namespace
+	Collect       *bool           `yaml:"collect-outputs"` // return the new files in the directories of the outputs
+	Tools         []executor.Tool `yaml:"tools"`
 }
 
 func toType[T any](value string) T {
@@ -79,6 +87,17 @@ func LoadConfig() (Config, error) {
 
 	loadValue(&config.TaskDatabase, "task-database", "Task database", "127.0.0.1:6379")
 	loadValue(&config.Concurrency, "concurrency", "Concurrency", runtime.NumCPU())
+	loadValue(&config.BlobCache, "blob-cache", "Directory caching the input files", filepath.Join(os.TempDir(), "all-build-blobs"))
+	loadValue(&config.BlobCacheSize, "blob-cache-size", "Size of the input file cache in MiB", 4096)
+	loadValue(&config.Workspaces, "workspaces", "Directory of the task workspaces", filepath.Join(os.TempDir(), "all-build-workspaces"))
+	loadValue(&config.Quota, "workspace-quota", "Disk space of all workspaces in MiB, 0 is unlimited", 0)
+	loadValue(&config.KeepFailed, "keep-failed", "Minutes to keep the workspaces of failed compilations", 0)
+	loadValue(&config.Collect, "collect-outputs", "Return the new files in the directories of the outputs too", false)
+	loadValue(&config.Sandbox, "sandbox", "How the workspace is hidden from the compiler: chroot, prefix-map or namespace", executor.SandboxChroot)
+
+	if err := executor.ValidSandbox(*config.Sandbox); err != nil {
+		return config, err
+	}
 
 	return config, nil
 }
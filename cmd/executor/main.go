@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"os"
+	"time"
 
+	"github.com/Zeeno-atl/all-build/internal/blobs"
+	"github.com/Zeeno-atl/all-build/internal/executor"
 	"github.com/Zeeno-atl/all-build/internal/tasks"
 	"github.com/golang/glog"
 	"github.com/hibiken/asynq"
+	"github.com/redis/go-redis/v9"
 )
 
 const (
@@ -19,6 +25,11 @@ func main() {
 	// V 2: Logging content of requests
 	// V 3: Tracing information
 
+	// the executor is executed again in the namespace of a task
+	if len(os.Args) > 1 && os.Args[1] == executor.SandboxArgument {
+		executor.RunSandbox(os.Args[2:])
+	}
+
 	flag.Parse()
 
 	// rewrite glog parameter
@@ -37,6 +48,36 @@ func main() {
 		queues[queue.Tag] = len(config.Tools) - i
 	}
 
+	redisClient := redis.NewClient(&redis.Options{Addr: *config.TaskDatabase})
+	for _, tool := range config.Tools {
+		fingerprint, err := tool.Fingerprint()
+		if err != nil {
+			glog.Warningf("could not fingerprint %s: %v", tool.Executable, err)
+			continue
+		}
+		if err := redisClient.Set(context.Background(), tasks.ToolKey(tool.Tag), fingerprint, 0).Err(); err != nil {
+			glog.Warningf("could not publish the fingerprint of %s: %v", tool.Executable, err)
+		}
+	}
+	store := blobs.NewRedisStore(redisClient, blobs.DefaultTTL)
+	blobCache, err := blobs.NewCache(*config.BlobCache, store)
+	if err != nil {
+		glog.Fatalln("Error creating blob cache:", err)
+	}
+	workspaces, err := executor.NewWorkspaces(*config.Workspaces, int64(*config.Quota)<<20, time.Duration(*config.KeepFailed)*time.Minute)
+	if err != nil {
+		glog.Fatalln("Error creating workspaces:", err)
+	}
+
+	go func() {
+		for range time.Tick(time.Minute) {
+			if err := blobCache.Trim(int64(*config.BlobCacheSize) << 20); err != nil {
+				glog.Warningf("could not trim blob cache: %v", err)
+			}
+			workspaces.Collect()
+		}
+	}()
+
 	srv := asynq.NewServer(
 		asynq.RedisClientOpt{Addr: *config.TaskDatabase},
 		asynq.Config{
@@ -50,7 +91,7 @@ func main() {
 
 	// mux maps a type to a handler
 	mux := asynq.NewServeMux()
-	mux.Handle(tasks.TypeCompileFile, tasks.NewCompileFileHandler(config.Tools))
+	mux.Handle(tasks.TypeCompileFile, tasks.NewCompileFileHandler(config.Tools, blobCache, redisClient, workspaces, *config.Sandbox, *config.Collect))
 
 	if err := srv.Run(mux); err != nil {
 		glog.Fatalf("could not run server: %v", err)
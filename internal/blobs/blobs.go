@@ -0,0 +1,25 @@
+package blobs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// DefaultTTL is how long an unused blob is kept in the shared store.
+const DefaultTTL = 24 * time.Hour
+
+// Hash returns the content address of a blob.
+func Hash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Store is a shared content-addressed store, blobs are keyed by their Hash.
+type Store interface {
+	// Missing returns the hashes which are not in the store.
+	Missing(ctx context.Context, hashes []string) ([]string, error)
+	Put(ctx context.Context, hash string, content []byte) error
+	Get(ctx context.Context, hash string) ([]byte, error)
+}
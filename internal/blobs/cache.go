@@ -0,0 +1,151 @@
+package blobs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Cache keeps blobs on the local disk of an executor, so the blobs shared by
+// many tasks are fetched from the Store only once.
+type Cache struct {
+	dir   string
+	store Store
+
+	// Trim holds it for writing, the blobs being materialised for reading
+	mutex sync.RWMutex
+}
+
+func NewCache(dir string, store Store) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create blob cache: %v", err)
+	}
+	return &Cache{dir: dir, store: store}, nil
+}
+
+func (c *Cache) path(hash string) string {
+	if len(hash) < 2 {
+		return filepath.Join(c.dir, hash)
+	}
+	return filepath.Join(c.dir, hash[:2], hash)
+}
+
+// fetch makes sure the blob is in the cache and returns its path.
+func (c *Cache) fetch(ctx context.Context, hash string) (string, error) {
+	path := c.path(hash)
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err == nil {
+		return path, nil
+	}
+
+	content, err := c.store.Get(ctx, hash)
+	if err != nil {
+		return "", err
+	}
+	if Hash(content) != hash {
+		return "", fmt.Errorf("blob %s is corrupted", hash)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("could not create blob directory: %v", err)
+	}
+	// write to a temporary file first, so concurrent tasks never see a partial blob
+	tmp, err := os.CreateTemp(filepath.Dir(path), hash+".*")
+	if err != nil {
+		return "", fmt.Errorf("could not create blob: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("could not write blob: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("could not write blob: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("could not store blob: %v", err)
+	}
+	return path, nil
+}
+
+// Materialise writes the blob to path.
+func (c *Cache) Materialise(ctx context.Context, hash string, path string, mode os.FileMode) error {
+	in, err := c.open(ctx, hash)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("could not create file: %v", err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("could not copy blob: %v", err)
+	}
+	return out.Close()
+}
+
+// open fetches the blob and opens it before Trim can remove it, an open blob
+// stays readable after it is removed.
+func (c *Cache) open(ctx context.Context, hash string) (*os.File, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	source, err := c.fetch(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	in, err := os.Open(source)
+	if err != nil {
+		return nil, fmt.Errorf("could not open blob: %v", err)
+	}
+	return in, nil
+}
+
+// Trim removes the least recently used blobs until the cache fits into maxBytes.
+func (c *Cache) Trim(maxBytes int64) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	type blob struct {
+		path    string
+		size    int64
+		lastUse time.Time
+	}
+
+	blobs := make([]blob, 0)
+	total := int64(0)
+	err := filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		blobs = append(blobs, blob{path: path, size: info.Size(), lastUse: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(blobs, func(i int, j int) bool {
+		return blobs[i].lastUse.Before(blobs[j].lastUse)
+	})
+
+	for _, b := range blobs {
+		if total <= maxBytes {
+			break
+		}
+		if err := os.Remove(b.path); err == nil {
+			total -= b.size
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package blobs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStore is a Store counting the blobs fetched from it.
+type memoryStore struct {
+	mutex sync.Mutex
+	blobs map[string][]byte
+	gets  int
+}
+
+func newMemoryStore(contents ...string) *memoryStore {
+	s := &memoryStore{blobs: make(map[string][]byte)}
+	for _, content := range contents {
+		s.blobs[Hash([]byte(content))] = []byte(content)
+	}
+	return s
+}
+
+func (s *memoryStore) Missing(ctx context.Context, hashes []string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	missing := make([]string, 0)
+	for _, hash := range hashes {
+		if _, ok := s.blobs[hash]; !ok {
+			missing = append(missing, hash)
+		}
+	}
+	return missing, nil
+}
+
+func (s *memoryStore) Put(ctx context.Context, hash string, content []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.blobs[hash] = content
+	return nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, hash string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.gets++
+	content, ok := s.blobs[hash]
+	if !ok {
+		return nil, fmt.Errorf("blob %s not found", hash)
+	}
+	return content, nil
+}
+
+func TestCacheMaterialise(t *testing.T) {
+	store := newMemoryStore("header")
+	cache, err := NewCache(t.TempDir(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for _, name := range []string{"a.h", "b.h"} {
+		path := filepath.Join(dir, name)
+		if err := cache.Materialise(context.Background(), Hash([]byte("header")), path, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if content, _ := os.ReadFile(path); string(content) != "header" {
+			t.Errorf("Expected %s to contain the blob, got %q", name, content)
+		}
+	}
+	if store.gets != 1 {
+		t.Errorf("Expected the blob to be fetched once, got %d", store.gets)
+	}
+
+	if err := cache.Materialise(context.Background(), Hash([]byte("missing")), filepath.Join(dir, "c.h"), 0644); err == nil {
+		t.Errorf("Expected a missing blob to fail")
+	}
+}
+
+func TestCacheCorruptedBlob(t *testing.T) {
+	store := newMemoryStore()
+	store.blobs[Hash([]byte("expected"))] = []byte("corrupted")
+	cache, _ := NewCache(t.TempDir(), store)
+
+	err := cache.Materialise(context.Background(), Hash([]byte("expected")), filepath.Join(t.TempDir(), "a.h"), 0644)
+	if err == nil {
+		t.Errorf("Expected a corrupted blob to fail")
+	}
+}
+
+func TestCacheTrim(t *testing.T) {
+	store := newMemoryStore("old", "new")
+	dir := t.TempDir()
+	cache, _ := NewCache(dir, store)
+
+	out := t.TempDir()
+	for _, content := range []string{"old", "new"} {
+		if err := cache.Materialise(context.Background(), Hash([]byte(content)), filepath.Join(out, content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(cache.path(Hash([]byte("old"))), past, past)
+
+	if err := cache.Trim(3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cache.path(Hash([]byte("old")))); !os.IsNotExist(err) {
+		t.Errorf("Expected the least recently used blob to be removed")
+	}
+	if _, err := os.Stat(cache.path(Hash([]byte("new")))); err != nil {
+		t.Errorf("Expected the recently used blob to be kept: %v", err)
+	}
+}
+
+func TestCacheTrimWhileMaterialising(t *testing.T) {
+	contents := make([]string, 0)
+	for i := 0; i < 10; i++ {
+		contents = append(contents, fmt.Sprintf("blob %d", i))
+	}
+	cache, _ := NewCache(t.TempDir(), newMemoryStore(contents...))
+	out := t.TempDir()
+
+	done, stopped := make(chan bool), make(chan bool)
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				cache.Trim(0)
+			}
+		}
+	}()
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+
+	var wait sync.WaitGroup
+	failures := make(chan error, 1000)
+	for i := 0; i < 8; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			for round := 0; round < 50; round++ {
+				for j, content := range contents {
+					path := filepath.Join(out, fmt.Sprintf("%d-%d", i, j))
+					if err := cache.Materialise(context.Background(), Hash([]byte(content)), path, 0644); err != nil {
+						select {
+						case failures <- err:
+						default:
+						}
+					}
+				}
+			}
+		}(i)
+	}
+	wait.Wait()
+	close(failures)
+	for err := range failures {
+		t.Fatalf("Materialise failed: %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package blobs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisPrefix = "all-build:blob:"
+
+type RedisStore struct {
+	client redis.UniversalClient
+	ttl    time.Duration
+}
+
+func NewRedisStore(client redis.UniversalClient, ttl time.Duration) *RedisStore {
+	return &RedisStore{client: client, ttl: ttl}
+}
+
+func (s *RedisStore) Missing(ctx context.Context, hashes []string) ([]string, error) {
+	pipe := s.client.Pipeline()
+	// EXPIRE both checks the blob exists and keeps the blobs in use alive
+	results := make([]*redis.BoolCmd, len(hashes))
+	for i, hash := range hashes {
+		results[i] = pipe.Expire(ctx, redisPrefix+hash, s.ttl)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("could not query blobs: %v", err)
+	}
+
+	missing := make([]string, 0)
+	for i, result := range results {
+		if !result.Val() {
+			missing = append(missing, hashes[i])
+		}
+	}
+	return missing, nil
+}
+
+func (s *RedisStore) Put(ctx context.Context, hash string, content []byte) error {
+	if err := s.client.Set(ctx, redisPrefix+hash, content, s.ttl).Err(); err != nil {
+		return fmt.Errorf("could not store blob %s: %v", hash, err)
+	}
+	return nil
+}
+
+func (s *RedisStore) Get(ctx context.Context, hash string) ([]byte, error) {
+	content, err := s.client.Get(ctx, redisPrefix+hash).Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("could not get blob %s: %v", hash, err)
+	}
+	return content, nil
+}
@@ -0,0 +1,262 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/Zeeno-atl/all-build/internal/tasks"
+)
+
+const (
+	resultsDir = "results"
+	statsFile  = "stats"
+	toolsDir   = "tools"
+)
+
+// counters are kept in the stats file as little-endian integers. The size of
+// the results is updated by every Put, so the bound is checked without walking
+// the cache, and made exact again by Trim.
+type counters struct {
+	Hits   int64
+	Misses int64
+	Bytes  int64
+}
+
+// Local is an on-disk cache of compilation results, keyed by the action hash
+// of a task. It is shared by all compiler invocations of the user, so it only
+// relies on atomic file operations.
+type Local struct {
+	dir      string
+	maxBytes int64
+}
+
+type Stats struct {
+	Hits    int64
+	Misses  int64
+	Entries int64
+	Bytes   int64
+}
+
+func NewLocal(dir string, maxBytes int64) (*Local, error) {
+	if err := os.MkdirAll(filepath.Join(dir, resultsDir), 0755); err != nil {
+		return nil, fmt.Errorf("could not create cache directory: %v", err)
+	}
+	c := &Local{dir: dir, maxBytes: maxBytes}
+	if _, err := os.Stat(filepath.Join(dir, statsFile)); os.IsNotExist(err) {
+		// the size of the results is counted from here on
+		if err := c.Trim(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *Local) path(key string) string {
+	return filepath.Join(c.dir, resultsDir, key[:2], key+".json")
+}
+
+func (c *Local) Get(key string) (*tasks.Response, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		c.update(func(counters *counters) { counters.Misses++ })
+		return nil, false
+	}
+
+	var response tasks.Response
+	if err := json.Unmarshal(data, &response); err != nil {
+		os.Remove(c.path(key))
+		c.update(func(counters *counters) { counters.Misses++; counters.Bytes -= int64(len(data)) })
+		return nil, false
+	}
+
+	// the modification time is the last use for the eviction
+	now := time.Now()
+	os.Chtimes(c.path(key), now, now)
+	c.update(func(counters *counters) { counters.Hits++ })
+	return &response, true
+}
+
+// Put stores a successful result, failed compilations are not cached.
+func (c *Local) Put(key string, response *tasks.Response) error {
+	if response.ReturnCode != 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("could not create cache directory: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+".*")
+	if err != nil {
+		return fmt.Errorf("could not create cache entry: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write cache entry: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not write cache entry: %v", err)
+	}
+	replaced := int64(0)
+	if info, err := os.Stat(path); err == nil {
+		replaced = info.Size()
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("could not store cache entry: %v", err)
+	}
+
+	total := int64(0)
+	c.update(func(counters *counters) {
+		counters.Bytes += int64(len(data)) - replaced
+		total = counters.Bytes
+	})
+	if total > c.maxBytes {
+		return c.Trim()
+	}
+	return nil
+}
+
+func (c *Local) toolPath(tag string) string {
+	// the tag is a name from the configuration, not a safe file name
+	sum := sha256.Sum256([]byte(tag))
+	return filepath.Join(c.dir, toolsDir, hex.EncodeToString(sum[:]))
+}
+
+// Tool returns the fingerprint of the tool of a tag seen last, so the results
+// are found without the task database.
+func (c *Local) Tool(tag string) (string, bool) {
+	data, err := os.ReadFile(c.toolPath(tag))
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// PutTool remembers the fingerprint of the tool of a tag.
+func (c *Local) PutTool(tag string, fingerprint string) error {
+	if current, ok := c.Tool(tag); ok && current == fingerprint {
+		return nil
+	}
+
+	path := c.toolPath(tag)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("could not create cache directory: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("could not create tool entry: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(fingerprint); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write tool entry: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not write tool entry: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("could not store tool entry: %v", err)
+	}
+	return nil
+}
+
+// update changes the counters under a lock of the stats file, so concurrent
+// invocations never lose an update.
+func (c *Local) update(change func(counters *counters)) counters {
+	current := counters{}
+	file, err := os.OpenFile(filepath.Join(c.dir, statsFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return current
+	}
+	defer file.Close()
+
+	if err := lockFile(file); err != nil {
+		return current
+	}
+	defer unlockFile(file)
+
+	// a missing or truncated file counts from zero
+	binary.Read(file, binary.LittleEndian, &current)
+	if change != nil {
+		change(&current)
+		if _, err := file.Seek(0, io.SeekStart); err == nil {
+			binary.Write(file, binary.LittleEndian, &current)
+		}
+	}
+	return current
+}
+
+type entry struct {
+	path    string
+	size    int64
+	lastUse time.Time
+}
+
+func (c *Local) entries() ([]entry, int64) {
+	entries := make([]entry, 0)
+	total := int64(0)
+	filepath.Walk(filepath.Join(c.dir, resultsDir), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		entries = append(entries, entry{path: path, size: info.Size(), lastUse: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	return entries, total
+}
+
+// Trim evicts the least recently used results until the cache fits its size.
+func (c *Local) Trim() error {
+	entries, total := c.entries()
+	sort.Slice(entries, func(i int, j int) bool {
+		return entries[i].lastUse.Before(entries[j].lastUse)
+	})
+
+	for _, e := range entries {
+		if total <= c.maxBytes {
+			break
+		}
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not evict cache entry: %v", err)
+		}
+		total -= e.size
+	}
+	c.update(func(counters *counters) { counters.Bytes = total })
+	return nil
+}
+
+func (c *Local) Stats() Stats {
+	entries, total := c.entries()
+	counters := c.update(nil)
+	return Stats{
+		Hits:    counters.Hits,
+		Misses:  counters.Misses,
+		Entries: int64(len(entries)),
+		Bytes:   total,
+	}
+}
+
+// Clear removes all results and resets the counters.
+func (c *Local) Clear() error {
+	for _, name := range []string{resultsDir, statsFile} {
+		if err := os.RemoveAll(filepath.Join(c.dir, name)); err != nil {
+			return fmt.Errorf("could not clear cache: %v", err)
+		}
+	}
+	return os.MkdirAll(filepath.Join(c.dir, resultsDir), 0755)
+}
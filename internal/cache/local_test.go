@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zeeno-atl/all-build/internal/tasks"
+)
+
+func key(i int) string {
+	return fmt.Sprintf("%064x", i)
+}
+
+func response(size int) *tasks.Response {
+	return &tasks.Response{Stdout: strings.Repeat("x", size)}
+}
+
+func TestLocalGetPut(t *testing.T) {
+	c, err := NewLocal(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := c.Get(key(1)); ok {
+		t.Errorf("Expected a miss")
+	}
+	if err := c.Put(key(1), response(10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put(key(2), &tasks.Response{ReturnCode: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if result, ok := c.Get(key(1)); !ok || result.Stdout != strings.Repeat("x", 10) {
+		t.Errorf("Expected a hit, got %v", result)
+	}
+	if _, ok := c.Get(key(2)); ok {
+		t.Errorf("Expected a failed compilation not to be cached")
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Misses != 2 || stats.Entries != 1 || stats.Bytes == 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestLocalEvictsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	c, _ := NewLocal(dir, 1<<20)
+	for i := 0; i < 3; i++ {
+		c.Put(key(i), response(100))
+		past := time.Now().Add(time.Duration(i-10) * time.Minute)
+		os.Chtimes(c.path(key(i)), past, past)
+	}
+	// a hit makes the oldest entry the most recently used
+	c.Get(key(0))
+
+	entrySize := c.Stats().Bytes / 3
+	c.maxBytes = 2 * entrySize
+	if err := c.Trim(); err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []bool{true, false, true} {
+		if _, err := os.Stat(c.path(key(i))); (err == nil) != expected {
+			t.Errorf("Expected entry %d to be kept: %v", i, expected)
+		}
+	}
+}
+
+func TestLocalBoundOnEveryPut(t *testing.T) {
+	c, _ := NewLocal(t.TempDir(), 1000)
+	for i := 0; i < 10; i++ {
+		if err := c.Put(key(i), response(300)); err != nil {
+			t.Fatal(err)
+		}
+		if stats := c.Stats(); stats.Bytes > 1000 {
+			t.Fatalf("Expected the cache to fit 1000 bytes after put %d, got %d", i, stats.Bytes)
+		}
+	}
+	if _, ok := c.Get(key(9)); !ok {
+		t.Errorf("Expected the last entry to be kept")
+	}
+}
+
+func TestLocalCountsConcurrently(t *testing.T) {
+	c, _ := NewLocal(t.TempDir(), 1<<20)
+	done := make(chan bool)
+	for i := 0; i < 10; i++ {
+		go func() {
+			for j := 0; j < 20; j++ {
+				c.Get(key(j))
+			}
+			done <- true
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		<-done
+	}
+	if stats := c.Stats(); stats.Misses != 200 {
+		t.Errorf("Expected 200 misses, got %d", stats.Misses)
+	}
+}
+
+func TestLocalClear(t *testing.T) {
+	dir := t.TempDir()
+	c, _ := NewLocal(dir, 1<<20)
+	c.Put(key(1), response(10))
+	c.Get(key(1))
+
+	if err := c.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := c.Stats(); stats != (Stats{}) {
+		t.Errorf("Expected empty stats, got %+v", stats)
+	}
+	if _, ok := c.Get(key(1)); ok {
+		t.Errorf("Expected the entry to be removed")
+	}
+}
+
+func TestLocalTool(t *testing.T) {
+	c, _ := NewLocal(t.TempDir(), 1<<20)
+
+	if _, ok := c.Tool("gcc"); ok {
+		t.Errorf("Expected no tool")
+	}
+	for _, fingerprint := range []string{"a", "b"} {
+		if err := c.PutTool("gcc", fingerprint); err != nil {
+			t.Fatal(err)
+		}
+		if stored, ok := c.Tool("gcc"); !ok || stored != fingerprint {
+			t.Errorf("Expected %q, got %q", fingerprint, stored)
+		}
+	}
+	// the tag does not name a file
+	if err := c.PutTool("../gcc", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := c.Tool("gcc"); stored != "b" {
+		t.Errorf("Expected the tags to be kept apart, got %q", stored)
+	}
+	// the tools are not counted as results
+	if stats := c.Stats(); stats.Entries != 0 {
+		t.Errorf("Expected no entries, got %+v", stats)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package cache
+
+import "os"
+
+// without flock the counters are best effort
+func lockFile(file *os.File) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Zeeno-atl/all-build/internal/tasks"
+	"github.com/redis/go-redis/v9"
+)
+
+// Access modes of the remote cache. Developer machines should only read, the
+// results are written by trusted clients, e.g. CI.
+const (
+	ModeOff       = "off"
+	ModeReadOnly  = "read-only"
+	ModeReadWrite = "read-write"
+)
+
+const remotePrefix = "all-build:result:"
+
+// Remote is a result cache shared by the whole team, stored in Redis next to the tasks.
+type Remote struct {
+	client       redis.UniversalClient
+	mode         string
+	ttl          time.Duration
+	maxEntrySize int
+}
+
+func NewRemote(client redis.UniversalClient, mode string, ttl time.Duration, maxEntrySize int) (*Remote, error) {
+	switch mode {
+	case ModeOff, ModeReadOnly, ModeReadWrite:
+	default:
+		return nil, fmt.Errorf("unknown remote cache mode: %s", mode)
+	}
+	return &Remote{client: client, mode: mode, ttl: ttl, maxEntrySize: maxEntrySize}, nil
+}
+
+func (c *Remote) Get(ctx context.Context, key string) (*tasks.Response, bool, error) {
+	if c.mode == ModeOff {
+		return nil, false, nil
+	}
+
+	data, err := c.client.Get(ctx, remotePrefix+key).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("could not get cached result: %v", err)
+	}
+
+	var response tasks.Response
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, false, fmt.Errorf("could not unmarshal cached result: %v", err)
+	}
+	return &response, true, nil
+}
+
+// Put stores a successful result, unless the cache is read-only or the result
+// is larger than the maximum entry size.
+func (c *Remote) Put(ctx context.Context, key string, response *tasks.Response) error {
+	if c.mode != ModeReadWrite || response.ReturnCode != 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	if len(data) > c.maxEntrySize {
+		return nil
+	}
+
+	if err := c.client.Set(ctx, remotePrefix+key, data, c.ttl).Err(); err != nil {
+		return fmt.Errorf("could not store result: %v", err)
+	}
+	return nil
+}
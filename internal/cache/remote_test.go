@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zeeno-atl/all-build/internal/tasks"
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis answers GET and SET in memory, no command reaches a server.
+type fakeRedis struct {
+	values map[string]string
+	ttls   map[string]time.Duration
+}
+
+func newFakeRedis() (*fakeRedis, redis.UniversalClient) {
+	fake := &fakeRedis{values: make(map[string]string), ttls: make(map[string]time.Duration)}
+	client := redis.NewClient(&redis.Options{Addr: "fake:6379"})
+	client.AddHook(fake)
+	return fake, client
+}
+
+func (f *fakeRedis) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		return nil, fmt.Errorf("no connection to a fake")
+	}
+}
+
+func (f *fakeRedis) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		args := cmd.Args()
+		switch cmd.Name() {
+		case "get":
+			value, ok := f.values[fmt.Sprint(args[1])]
+			if !ok {
+				cmd.SetErr(redis.Nil)
+				return redis.Nil
+			}
+			cmd.(*redis.StringCmd).SetVal(value)
+		case "set":
+			key := fmt.Sprint(args[1])
+			f.values[key] = string(args[2].([]byte))
+			f.ttls[key] = 0
+			if len(args) == 5 && args[3] == "px" {
+				f.ttls[key] = time.Duration(args[4].(int64)) * time.Millisecond
+			} else if len(args) == 5 && args[3] == "ex" {
+				f.ttls[key] = time.Duration(args[4].(int64)) * time.Second
+			}
+			cmd.(*redis.StatusCmd).SetVal("OK")
+		default:
+			err := fmt.Errorf("unexpected command: %v", args)
+			cmd.SetErr(err)
+			return err
+		}
+		return nil
+	}
+}
+
+func (f *fakeRedis) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestRemotePut(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		name     string
+		mode     string
+		response *tasks.Response
+		stored   bool
+	}{
+		{"read-write", ModeReadWrite, response(10), true},
+		{"read-only", ModeReadOnly, response(10), false},
+		{"off", ModeOff, response(10), false},
+		{"failed compilation", ModeReadWrite, &tasks.Response{ReturnCode: 1}, false},
+		{"oversized entry", ModeReadWrite, response(2048), false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake, client := newFakeRedis()
+			c, err := NewRemote(client, tc.mode, 24*time.Hour, 1024)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := c.Put(ctx, key(1), tc.response); err != nil {
+				t.Fatal(err)
+			}
+			if _, stored := fake.values[remotePrefix+key(1)]; stored != tc.stored {
+				t.Errorf("Expected stored %v, got %v", tc.stored, stored)
+			}
+			if !tc.stored {
+				return
+			}
+
+			if ttl := fake.ttls[remotePrefix+key(1)]; ttl != 24*time.Hour {
+				t.Errorf("Expected the entry to expire in 24h, got %v", ttl)
+			}
+			if result, ok, err := c.Get(ctx, key(1)); err != nil || !ok || result.Stdout != tc.response.Stdout {
+				t.Errorf("Expected a hit, got %v %v %v", result, ok, err)
+			}
+		})
+	}
+}
+
+func TestRemoteGet(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newFakeRedis()
+	fake.values[remotePrefix+key(1)] = `{"returnCode":0,"stdout":"x","files":[]}`
+	fake.values[remotePrefix+key(2)] = "not json"
+
+	c, _ := NewRemote(client, ModeReadOnly, time.Hour, 1024)
+	if result, ok, err := c.Get(ctx, key(1)); err != nil || !ok || result.Stdout != "x" {
+		t.Errorf("Expected a hit, got %v %v %v", result, ok, err)
+	}
+	if _, ok, err := c.Get(ctx, key(3)); err != nil || ok {
+		t.Errorf("Expected a miss, got %v %v", ok, err)
+	}
+	if _, _, err := c.Get(ctx, key(2)); err == nil || !strings.Contains(err.Error(), "unmarshal") {
+		t.Errorf("Expected a broken entry to fail, got %v", err)
+	}
+
+	// a disabled cache is not queried at all
+	off, _ := NewRemote(client, ModeOff, time.Hour, 1024)
+	if _, ok, err := off.Get(ctx, key(1)); err != nil || ok {
+		t.Errorf("Expected a disabled cache to miss, got %v %v", ok, err)
+	}
+	if _, err := NewRemote(client, "write-only", time.Hour, 1024); err == nil {
+		t.Errorf("Expected an unknown mode to fail")
+	}
+}
@@ -0,0 +1,67 @@
+package executor
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// how the executor hides its workspace from the compiler
+const (
+	SandboxChroot    = "chroot"     // the paths are prefixed with the workspace
+	SandboxPrefixMap = "prefix-map" // as chroot, the workspace is mapped back by -ffile-prefix-map
+	SandboxNamespace = "namespace"  // the workspace is mounted at the client paths (linux only)
+)
+
+// SandboxArgument is the first argument of the executor re-executed to enter the sandbox.
+const SandboxArgument = "__sandbox"
+
+// directories of the namespace sandbox in the workspace
+const (
+	sandboxRoot    = ".sandbox" // the root directory of the compiler
+	sandboxOverlay = ".overlay" // the work directories of the overlays
+	sandboxTemp    = ".tmp"     // the temporary files, mounted at /.tmp
+)
+
+func ValidSandbox(sandbox string) error {
+	switch sandbox {
+	case SandboxChroot, SandboxPrefixMap, SandboxNamespace:
+		return nil
+	}
+	return fmt.Errorf("unknown sandbox: %s", sandbox)
+}
+
+// PrefixMapArguments maps the workspace back to the client paths in the
+// debug info, __FILE__ and the like: the workspace is the working directory
+// of the client and every absolute path is mapped to itself.
+func PrefixMapArguments(workspace string, cwd string, clientPaths []string) []string {
+	if cwd == "" {
+		cwd = "."
+	}
+	args := []string{fmt.Sprintf("-ffile-prefix-map=%s=%s", workspace, cwd)}
+
+	// the last matching map wins
+	roots := make(map[string]bool)
+	for _, path := range clientPaths {
+		if !filepath.IsAbs(path) {
+			continue
+		}
+		root := strings.SplitN(filepath.Clean(path)[1:], "/", 2)[0]
+		if root != "" && !roots[root] {
+			roots[root] = true
+			args = append(args, fmt.Sprintf("-ffile-prefix-map=%s=/%s", filepath.Join(workspace, root), root))
+		}
+	}
+	return args
+}
+
+// RemoveSandbox removes the directories of the namespace sandbox from the
+// workspace. The overlays leave work directories nobody can read.
+func RemoveSandbox(workspace string) error {
+	for _, dir := range []string{sandboxRoot, sandboxOverlay} {
+		if err := removeTree(filepath.Join(workspace, dir)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+//go:build linux
+
+package executor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// SandboxCommand runs the tool in a user and mount namespace, in which the
+// workspace is mounted at the paths of the client and cwd is its working
+// directory. The executor is executed again to set up the mounts.
+func SandboxCommand(ctx context.Context, workspace string, cwd string, executable string, args []string) (*exec.Cmd, error) {
+	if !filepath.IsAbs(cwd) {
+		return nil, fmt.Errorf("the working directory of the client is not known")
+	}
+	cmd := exec.CommandContext(ctx, "/proc/self/exe", append([]string{SandboxArgument, workspace, cwd, executable}, args...)...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:                 syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+		UidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+		GidMappingsEnableSetgroups: false,
+	}
+	return cmd, nil
+}
+
+// RunSandbox sets up the mounts and executes the tool, the arguments are the
+// ones of SandboxCommand. It does not return.
+func RunSandbox(args []string) {
+	if len(args) < 3 {
+		fmt.Fprintln(os.Stderr, "sandbox: missing arguments")
+		os.Exit(127)
+	}
+	workspace, cwd, executable := args[0], args[1], args[2]
+
+	if err := enterSandbox(workspace, cwd); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		os.Exit(127)
+	}
+
+	path, err := exec.LookPath(executable)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		os.Exit(127)
+	}
+	// the temporary files count into the workspace quota
+	temp := "/" + sandboxTemp
+	env := append(os.Environ(), "TMPDIR="+temp, "TMP="+temp, "TEMP="+temp)
+	err = syscall.Exec(path, append([]string{executable}, args[3:]...), env)
+	fmt.Fprintf(os.Stderr, "sandbox: could not execute %s: %v\n", executable, err)
+	os.Exit(127)
+}
+
+// enterSandbox builds a root of the host directories and the workspace and
+// changes into it. The top level directories of the client paths are the
+// workspace directories overlaid on the host ones, so the compiler sees both
+// and writes its outputs into the workspace.
+func enterSandbox(workspace string, cwd string) error {
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("could not make the mounts private: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(workspace, cwd), 0755); err != nil {
+		return err
+	}
+
+	root := filepath.Join(workspace, sandboxRoot)
+	temp := filepath.Join(workspace, sandboxTemp)
+	for _, dir := range []string{root, temp} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	if err := syscall.Mount("tmpfs", root, "tmpfs", 0, "mode=0755"); err != nil {
+		return fmt.Errorf("could not mount the root: %v", err)
+	}
+
+	client := make(map[string]bool)
+	entries, err := os.ReadDir(workspace)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() != sandboxRoot && entry.Name() != sandboxOverlay && entry.Name() != sandboxTemp {
+			client[entry.Name()] = true
+		}
+	}
+
+	host, err := os.ReadDir("/")
+	if err != nil {
+		return err
+	}
+	for _, entry := range host {
+		// the temporary directory hides the one of the host
+		if client[entry.Name()] || entry.Name() == sandboxTemp {
+			continue
+		}
+		source := filepath.Join("/", entry.Name())
+		target := filepath.Join(root, entry.Name())
+		switch {
+		case entry.Type()&os.ModeSymlink != 0:
+			link, err := os.Readlink(source)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		case entry.IsDir():
+			if err := os.Mkdir(target, 0755); err != nil {
+				return err
+			}
+			if err := syscall.Mount(source, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+				return fmt.Errorf("could not mount %s: %v", source, err)
+			}
+		default:
+			if err := os.WriteFile(target, nil, 0644); err != nil {
+				return err
+			}
+			if err := syscall.Mount(source, target, "", syscall.MS_BIND, ""); err != nil {
+				return fmt.Errorf("could not mount %s: %v", source, err)
+			}
+		}
+	}
+
+	for name := range client {
+		source := filepath.Join(workspace, name)
+		target := filepath.Join(root, name)
+		if err := os.Mkdir(target, 0755); err != nil {
+			return err
+		}
+
+		info, err := os.Lstat(filepath.Join("/", name))
+		if err != nil || !info.IsDir() {
+			if err := syscall.Mount(source, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+				return fmt.Errorf("could not mount %s: %v", source, err)
+			}
+			continue
+		}
+
+		work := filepath.Join(workspace, sandboxOverlay, name)
+		if err := os.MkdirAll(work, 0755); err != nil {
+			return err
+		}
+		options := fmt.Sprintf("lowerdir=/%s,upperdir=%s,workdir=%s,userxattr", name, source, work)
+		if err := syscall.Mount("overlay", target, "overlay", 0, options); err != nil {
+			return fmt.Errorf("could not overlay /%s: %v", name, err)
+		}
+	}
+
+	if err := os.Mkdir(filepath.Join(root, sandboxTemp), 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount(temp, filepath.Join(root, sandboxTemp), "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("could not mount the temporary directory: %v", err)
+	}
+
+	if err := syscall.Chroot(root); err != nil {
+		return fmt.Errorf("could not change the root: %v", err)
+	}
+	return os.Chdir(cwd)
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package executor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+func SandboxCommand(ctx context.Context, workspace string, cwd string, executable string, args []string) (*exec.Cmd, error) {
+	return nil, fmt.Errorf("the namespace sandbox is only supported on linux")
+}
+
+func RunSandbox(args []string) {
+	fmt.Fprintln(os.Stderr, "sandbox: only supported on linux")
+	os.Exit(127)
+}
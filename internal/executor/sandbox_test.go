@@ -0,0 +1,29 @@
+package executor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPrefixMapArguments(t *testing.T) {
+	cases := []struct {
+		name        string
+		cwd         string
+		clientPaths []string
+		expected    []string
+	}{
+		{"unknown cwd", "", nil, []string{"-ffile-prefix-map=/ws=."}},
+		{"cwd", "/home/dev/project", nil, []string{"-ffile-prefix-map=/ws=/home/dev/project"}},
+		{"roots", "/home/dev/project", []string{"/usr/include/stdio.h", "/home/dev/project/a.c", "/usr/lib/gcc", "/opt//sdk/../sdk/x.h"},
+			[]string{"-ffile-prefix-map=/ws=/home/dev/project", "-ffile-prefix-map=/ws/usr=/usr", "-ffile-prefix-map=/ws/home=/home", "-ffile-prefix-map=/ws/opt=/opt"}},
+		{"relative paths", "/src", []string{"inc/a.h", "../b.h"}, []string{"-ffile-prefix-map=/ws=/src"}},
+		{"root itself", "/src", []string{"/"}, []string{"-ffile-prefix-map=/ws=/src"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if args := PrefixMapArguments("/ws", tc.cwd, tc.clientPaths); !reflect.DeepEqual(args, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, args)
+			}
+		})
+	}
+}
@@ -1,6 +1,38 @@
 package executor
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
 type Tool struct {
 	Executable string `yaml:"executable"`
 	Tag        string `yaml:"tag"`
 }
+
+// Fingerprint identifies the toolchain behind the tag: the content of the
+// executable and its --version. An upgraded compiler gives new cache keys.
+func (t Tool) Fingerprint() (string, error) {
+	path, err := exec.LookPath(t.Executable)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("could not read %s: %v", path, err)
+	}
+	// the driver may stay the same while its compilers are upgraded
+	version, _ := exec.Command(path, "--version").CombinedOutput()
+	h.Write(version)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
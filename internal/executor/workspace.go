@@ -0,0 +1,244 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	workspacePrefix = "all-build-"
+	// directories not used by this executor for so long are left from a previous run
+	staleAfter = time.Hour
+)
+
+// Workspaces manages the directories the tasks are compiled in. It limits
+// the disk space they take, removes them when the task is done and keeps
+// the failed ones for a while, if asked to.
+type Workspaces struct {
+	root       string
+	quota      int64 // bytes, 0 is unlimited
+	keepFailed time.Duration
+
+	mutex    sync.Mutex
+	released chan struct{} // closed and replaced whenever disk space is freed
+	used     int64
+	live     map[string]int64
+	kept     map[string]keptWorkspace
+}
+
+type keptWorkspace struct {
+	size    int64
+	expires time.Time
+}
+
+// Workspace is the directory of a task. The tool runs in Dir and writes its
+// temporary files into Temp, so both count into the quota.
+type Workspace struct {
+	Dir  string
+	Temp string
+
+	base     string
+	manager  *Workspaces
+	reserved int64
+}
+
+func NewWorkspaces(root string, quota int64, keepFailed time.Duration) (*Workspaces, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("could not create workspace root: %v", err)
+	}
+
+	w := &Workspaces{
+		root:       root,
+		quota:      quota,
+		keepFailed: keepFailed,
+		released:   make(chan struct{}),
+		live:       make(map[string]int64),
+		kept:       make(map[string]keptWorkspace),
+	}
+	w.sweep(0)
+	return w, nil
+}
+
+// Acquire creates a new workspace for a task needing about size bytes. While
+// the workspaces would not fit into the quota, it waits for others to be released.
+func (w *Workspaces) Acquire(ctx context.Context, size int64) (*Workspace, error) {
+	w.mutex.Lock()
+	for w.quota > 0 && w.used > 0 && w.used+size > w.quota {
+		glog.V(2).Infof("workspace quota exceeded (%d + %d > %d bytes), waiting", w.used, size, w.quota)
+		released := w.released
+		w.mutex.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		w.mutex.Lock()
+	}
+	w.used += size
+	w.mutex.Unlock()
+
+	base, err := os.MkdirTemp(w.root, workspacePrefix+"*")
+	if err != nil {
+		w.free(size)
+		return nil, fmt.Errorf("could not create workspace: %v", err)
+	}
+	ws := &Workspace{Dir: filepath.Join(base, "root"), Temp: filepath.Join(base, "tmp"), base: base, manager: w, reserved: size}
+	for _, dir := range []string{ws.Dir, ws.Temp} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			removeTree(base)
+			w.free(size)
+			return nil, fmt.Errorf("could not create workspace: %v", err)
+		}
+	}
+
+	w.mutex.Lock()
+	w.live[base] = size
+	w.mutex.Unlock()
+
+	return ws, nil
+}
+
+// Measure counts the outputs and the temporary files of the task into the
+// quota, when the workspace grew over the size reserved for the inputs.
+func (ws *Workspace) Measure() {
+	size := directorySize(ws.base)
+
+	w := ws.manager
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if size > ws.reserved {
+		w.used += size - ws.reserved
+		w.live[ws.base] = size
+		ws.reserved = size
+	}
+}
+
+func (w *Workspaces) free(size int64) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.used -= size
+	close(w.released)
+	w.released = make(chan struct{})
+}
+
+// Release removes the workspace. Failed workspaces are kept for debugging
+// when keep-failed is set, they still count into the quota until they expire.
+func (ws *Workspace) Release(failed bool) {
+	w := ws.manager
+
+	w.mutex.Lock()
+	delete(w.live, ws.base)
+	reserved := ws.reserved
+	w.mutex.Unlock()
+
+	if failed && w.keepFailed > 0 {
+		size := directorySize(ws.base)
+		glog.Infof("keeping failed workspace %s until %s", ws.base, time.Now().Add(w.keepFailed).Format(time.RFC3339))
+
+		w.mutex.Lock()
+		w.kept[ws.base] = keptWorkspace{size: size, expires: time.Now().Add(w.keepFailed)}
+		w.used += size
+		w.mutex.Unlock()
+	} else if err := removeTree(ws.base); err != nil {
+		glog.Warningf("could not remove workspace %s: %v", ws.base, err)
+	}
+
+	w.free(reserved)
+}
+
+// Collect removes the expired failed workspaces and the stale ones left by
+// a previous run of the executor.
+func (w *Workspaces) Collect() {
+	now := time.Now()
+
+	w.mutex.Lock()
+	expired := make(map[string]int64)
+	for dir, kept := range w.kept {
+		if now.After(kept.expires) {
+			expired[dir] = kept.size
+			delete(w.kept, dir)
+		}
+	}
+	w.mutex.Unlock()
+
+	for dir, size := range expired {
+		glog.V(1).Infof("removing expired workspace %s", dir)
+		if err := removeTree(dir); err != nil {
+			glog.Warningf("could not remove workspace %s: %v", dir, err)
+		}
+		w.free(size)
+	}
+
+	w.sweep(staleAfter)
+}
+
+// sweep removes the workspaces this executor does not know about, which were
+// not modified for the given time.
+func (w *Workspaces) sweep(age time.Duration) {
+	entries, err := os.ReadDir(w.root)
+	if err != nil {
+		glog.Warningf("could not list workspaces: %v", err)
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), workspacePrefix) {
+			continue
+		}
+		dir := filepath.Join(w.root, entry.Name())
+
+		w.mutex.Lock()
+		_, live := w.live[dir]
+		_, kept := w.kept[dir]
+		w.mutex.Unlock()
+		if live || kept {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < age {
+			continue
+		}
+
+		glog.V(1).Infof("removing stale workspace %s", dir)
+		if err := removeTree(dir); err != nil {
+			glog.Warningf("could not remove workspace %s: %v", dir, err)
+		}
+	}
+}
+
+func directorySize(dir string) int64 {
+	size := int64(0)
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// removeTree removes a directory, also when it holds directories without
+// permissions, e.g. the overlay work directories of a crashed sandbox.
+func removeTree(path string) error {
+	if err := os.RemoveAll(path); err == nil {
+		return nil
+	}
+	// WalkDir visits a directory before reading it, so it is made accessible first
+	filepath.WalkDir(path, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.IsDir() {
+			os.Chmod(path, 0700)
+		}
+		return nil
+	})
+	return os.RemoveAll(path)
+}
@@ -0,0 +1,154 @@
+package executor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func workspaceCount(t *testing.T, root string) int {
+	t.Helper()
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+func TestWorkspacesQuota(t *testing.T) {
+	w, err := NewWorkspaces(t.TempDir(), 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := w.Acquire(context.Background(), 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan *Workspace)
+	go func() {
+		second, err := w.Acquire(context.Background(), 80)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- second
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("Expected the second workspace to wait for the quota")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	first.Release(false)
+	second := <-acquired
+	if _, err := os.Stat(first.Dir); !os.IsNotExist(err) {
+		t.Errorf("Expected the released workspace to be removed")
+	}
+	second.Release(false)
+	if w.used != 0 {
+		t.Errorf("Expected no used space, got %d", w.used)
+	}
+}
+
+func TestWorkspacesQuotaCancelled(t *testing.T) {
+	w, _ := NewWorkspaces(t.TempDir(), 100, 0)
+	first, _ := w.Acquire(context.Background(), 100)
+	defer first.Release(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := w.Acquire(ctx, 10); err == nil {
+		t.Errorf("Expected a cancelled wait to fail")
+	}
+}
+
+func TestWorkspaceMeasure(t *testing.T) {
+	w, _ := NewWorkspaces(t.TempDir(), 1000, 0)
+	ws, err := w.Acquire(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// outputs and temporary files grow the workspace over its inputs
+	os.WriteFile(filepath.Join(ws.Dir, "a.o"), []byte(strings.Repeat("o", 300)), 0644)
+	os.WriteFile(filepath.Join(ws.Temp, "cc.s"), []byte(strings.Repeat("s", 200)), 0644)
+	ws.Measure()
+	if w.used != 500 {
+		t.Errorf("Expected 500 used bytes, got %d", w.used)
+	}
+
+	ws.Release(false)
+	if w.used != 0 {
+		t.Errorf("Expected no used space, got %d", w.used)
+	}
+}
+
+func TestWorkspacesKeepFailed(t *testing.T) {
+	root := t.TempDir()
+	w, _ := NewWorkspaces(root, 0, time.Hour)
+
+	failed, _ := w.Acquire(context.Background(), 0)
+	os.WriteFile(filepath.Join(failed.Dir, "a.c"), []byte("int"), 0644)
+	failed.Release(true)
+	succeeded, _ := w.Acquire(context.Background(), 0)
+	succeeded.Release(false)
+
+	if _, err := os.Stat(filepath.Join(failed.Dir, "a.c")); err != nil {
+		t.Errorf("Expected the failed workspace to be kept: %v", err)
+	}
+	if w.used != 3 {
+		t.Errorf("Expected the kept workspace to count into the quota, got %d", w.used)
+	}
+
+	w.Collect()
+	if workspaceCount(t, root) != 1 {
+		t.Errorf("Expected the kept workspace to survive a collection before it expires")
+	}
+	for dir, kept := range w.kept {
+		kept.expires = time.Now().Add(-time.Second)
+		w.kept[dir] = kept
+	}
+	w.Collect()
+	if workspaceCount(t, root) != 0 || w.used != 0 {
+		t.Errorf("Expected the expired workspace to be removed, used %d", w.used)
+	}
+}
+
+func TestWorkspacesSweep(t *testing.T) {
+	root := t.TempDir()
+
+	// an overlay of a crashed sandbox leaves directories nobody can read
+	stale := filepath.Join(root, workspacePrefix+"stale")
+	work := filepath.Join(stale, "root", sandboxOverlay, "home", "work")
+	if err := os.MkdirAll(filepath.Join(work, "inner"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(filepath.Join(work, "inner"), 0)
+	os.Chmod(work, 0)
+	other := filepath.Join(root, "other")
+	os.Mkdir(other, 0755)
+
+	w, err := NewWorkspaces(root, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("Expected the stale workspace to be removed: %v", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("Expected other directories to be kept: %v", err)
+	}
+
+	// the live ones and the recent ones are not stale
+	live, _ := w.Acquire(context.Background(), 0)
+	defer live.Release(false)
+	w.sweep(0)
+	if _, err := os.Stat(live.Dir); err != nil {
+		t.Errorf("Expected the live workspace to be kept: %v", err)
+	}
+}
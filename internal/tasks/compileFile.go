@@ -1,23 +1,32 @@
 package tasks
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/Zeeno-atl/all-build/internal/blobs"
 	"github.com/Zeeno-atl/all-build/internal/executor"
 	"github.com/Zeeno-atl/all-build/internal/utils"
 	"github.com/Zeeno-atl/all-build/pkg/compiler"
 	"github.com/golang/glog"
 	"github.com/hibiken/asynq"
+	"github.com/redis/go-redis/v9"
 )
 
+// measureInterval is how often the size of a running workspace is updated.
+const measureInterval = time.Second
+
 type CompileFile struct {
 	Tag         string   `json:"tag"`
 	Command     []string `json:"command"`
@@ -25,12 +34,20 @@ type CompileFile struct {
 	Outputs     []string `json:"outputs"`
 	Environment []string `json:"environment"`
 	Compiler    string   `json:"compiler"`
+	Cwd         string   `json:"cwd"`  // the working directory of the client
+	Tool        string   `json:"tool"` // the fingerprint of the tool published by the executors
 }
 
+// walkFilesystem returns the files, the links and the empty directories in path.
 func walkFilesystem(path string) []string {
 	files := make([]string, 0)
 	filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
-		if info != nil && !info.IsDir() {
+		if info == nil {
+			return nil
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+		} else if entries, err := os.ReadDir(path); err == nil && len(entries) == 0 {
 			files = append(files, path)
 		}
 		return nil
@@ -38,56 +55,166 @@ func walkFilesystem(path string) []string {
 	return files
 }
 
-func NewCompileFile(args []string, tag string, compilerType string) (*asynq.Task, error) {
-	compilerInstance := compiler.NewCompiler(compilerType)
-
-	if compilerInstance == nil {
-		return nil, fmt.Errorf("unknown compiler: %s", compilerType)
+// scanInputs returns the files needed by the compiler, following the includes
+// of every source. Includes which cannot be resolved fall back to shipping the
+// whole directory, as walkInputs does.
+func scanInputs(c compiler.ICompiler) []string {
+	scanner := compiler.NewIncludeScanner(c)
+	result := scanner.Scan()
+	if result.Incomplete {
+		log.Printf("scanning the includes took too long, shipping the directories of the inputs")
+		return walkInputs(c)
 	}
 
-	compilerInstance.Parse(args)
+	searchPath := scanner.SearchPath()
+	filePaths := result.Files
 
-	inputs := compiler.GetInputs(compilerInstance)
-	inputs = utils.Unique(inputs)
+	for _, input := range compiler.GetInputs(c) {
+		if utils.Contains(searchPath, input) || utils.Contains(scanner.Sources, input) {
+			continue
+		}
+		info, err := os.Stat(input)
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			filePaths = append(filePaths, walkFilesystem(input)...)
+		} else {
+			filePaths = append(filePaths, input)
+		}
+	}
 
-	filePaths := make([]string, 0)
+	for _, include := range result.Unresolved {
+		log.Printf("could not resolve '%s' included from %s, shipping its directory", include.Name, include.From)
+		filePaths = append(filePaths, walkFilesystem(filepath.Dir(include.From))...)
+		if include.Computed {
+			// a computed include can name any header in the search path
+			for _, dir := range searchPath {
+				filePaths = append(filePaths, walkFilesystem(dir)...)
+			}
+		}
+	}
 
-	for _, input := range inputs {
+	return filePaths
+}
+
+// walkInputs ships the whole directory of every input, the response files
+// are shipped alone.
+func walkInputs(c compiler.ICompiler) []string {
+	responseFiles := compiler.GetResponseFiles(c)
+	filePaths := append([]string{}, responseFiles...)
+	for _, input := range utils.Unique(compiler.GetInputs(c)) {
+		if utils.Contains(responseFiles, input) {
+			continue
+		}
 		baseDir := filepath.Dir(input)
 		filePaths = append(filePaths, walkFilesystem(baseDir)...)
 	}
-	filePaths = utils.Unique(filePaths)
+	return filePaths
+}
 
-	inputFiles := utils.Map(filePaths, func(path string) File {
-		content, err := os.ReadFile(path)
+func NewCompileFile(args []string, tag string, compilerType string, scanIncludes bool) (*CompileFile, error) {
+	compilerInstance := compiler.NewCompiler(compilerType)
 
-		if err != nil {
-			log.Fatalf("could not read file: %v", err)
-		}
+	if compilerInstance == nil {
+		return nil, fmt.Errorf("unknown compiler: %s", compilerType)
+	}
 
-		info, err := os.Stat(path)
-		if err != nil {
-			log.Fatalf("could not get file info: %v", err)
-		}
+	compilerInstance.Parse(args)
 
-		return File{
-			Path:    path,
-			Content: content,
-			Chmod:   int(info.Mode().Perm()),
-		}
-	})
+	var filePaths []string
+	if scanIncludes {
+		filePaths = scanInputs(compilerInstance)
+	} else {
+		filePaths = walkInputs(compilerInstance)
+	}
+	filePaths = utils.Unique(filePaths)
+
+	inputFiles, err := readInputs(filePaths)
+	if err != nil {
+		return nil, err
+	}
 
 	outputs := compiler.GetOutputs(compilerInstance)
 
-	cf := CompileFile{
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("could not get working directory: %v", err)
+	}
+
+	return &CompileFile{
 		Tag:         tag,
 		Command:     args,
 		Inputs:      inputFiles,
 		Outputs:     outputs,
 		Environment: make([]string, 0),
 		Compiler:    compilerType,
+		Cwd:         cwd,
+	}, nil
+}
+
+// Upload sends the inputs missing in the store and leaves only their hashes in
+// the task, so the executor materialises them from its cache.
+func (cf *CompileFile) Upload(ctx context.Context, store blobs.Store) error {
+	contents := make(map[string][]byte)
+	for _, file := range cf.Inputs {
+		if file.Hash != "" {
+			contents[file.Hash] = file.Content
+		}
+	}
+	hashes := make([]string, 0, len(contents))
+	for hash := range contents {
+		hashes = append(hashes, hash)
 	}
 
+	missing, err := store.Missing(ctx, hashes)
+	if err != nil {
+		return err
+	}
+	for _, hash := range missing {
+		if err := store.Put(ctx, hash, contents[hash]); err != nil {
+			return err
+		}
+	}
+
+	for i := range cf.Inputs {
+		cf.Inputs[i].Content = nil
+	}
+	return nil
+}
+
+// ActionHash identifies the result of the task: the same tool, normalised
+// command and inputs always give the same outputs.
+func (cf *CompileFile) ActionHash() string {
+	h := sha256.New()
+	// the paths of the client end up in the debug info
+	fmt.Fprintf(h, "tag=%s\ntool=%s\ncompiler=%s\ncwd=%s\n", cf.Tag, cf.Tool, cf.Compiler, cf.Cwd)
+
+	command := cf.Command
+	if compilerInstance := compiler.NewCompiler(cf.Compiler); compilerInstance != nil {
+		compilerInstance.Parse(cf.Command)
+		command = compiler.GetCommand(compilerInstance)
+	}
+	for _, arg := range command {
+		fmt.Fprintf(h, "arg=%s\n", arg)
+	}
+
+	inputs := append([]File{}, cf.Inputs...)
+	sort.Slice(inputs, func(i int, j int) bool { return inputs[i].Path < inputs[j].Path })
+	for _, input := range inputs {
+		fmt.Fprintf(h, "input=%s %o %s %s %t\n", input.Path, input.Chmod, input.Hash, input.Link, input.Dir)
+	}
+	for _, output := range cf.Outputs {
+		fmt.Fprintf(h, "output=%s\n", output)
+	}
+	for _, env := range cf.Environment {
+		fmt.Fprintf(h, "env=%s\n", env)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (cf *CompileFile) Task() (*asynq.Task, error) {
 	payload, err := json.Marshal(cf)
 	if err != nil {
 		return nil, err
@@ -97,27 +224,86 @@ func NewCompileFile(args []string, tag string, compilerType string) (*asynq.Task
 }
 
 type CompileFileHandler struct {
-	Tools []executor.Tool
+	Tools      []executor.Tool
+	Blobs      *blobs.Cache
+	Redis      redis.UniversalClient // publishes the results, may be nil
+	Workspaces *executor.Workspaces
+	Sandbox    string // executor.SandboxChroot, SandboxPrefixMap or SandboxNamespace
+	// CollectOutputs returns the new files in the directories of the outputs too
+	CollectOutputs bool
+}
+
+func NewCompileFileHandler(tools []executor.Tool, blobCache *blobs.Cache, redisClient redis.UniversalClient, workspaces *executor.Workspaces, sandbox string, collectOutputs bool) *CompileFileHandler {
+	return &CompileFileHandler{Tools: tools, Blobs: blobCache, Redis: redisClient, Workspaces: workspaces, Sandbox: sandbox, CollectOutputs: collectOutputs}
+}
+
+// newOutputs returns the files the compiler created in the directories of the
+// outputs besides the requested ones, e.g. the dumps of -fdump-tree-all.
+func newOutputs(p CompileFile, workspacePath func(path string) string) []string {
+	known := make(map[string]bool)
+	for _, file := range p.Inputs {
+		known[workspacePath(file.Path)] = true
+	}
+	for _, output := range p.Outputs {
+		known[workspacePath(output)] = true
+	}
+
+	// the directory outputs are returned whole
+	trees := utils.Map(utils.Filter(p.Outputs, isTree), func(output string) string {
+		return workspacePath(output) + string(filepath.Separator)
+	})
+
+	outputs := make([]string, 0)
+	for _, dir := range utils.Unique(utils.Map(p.Outputs, filepath.Dir)) {
+		root := workspacePath(dir)
+		for _, path := range walkFilesystem(root) {
+			if known[path] || utils.ContainsIf(trees, func(tree string) bool { return strings.HasPrefix(path, tree) }) {
+				continue
+			}
+			known[path] = true
+			if rel, err := filepath.Rel(root, path); err == nil {
+				outputs = append(outputs, filepath.Join(dir, rel))
+			}
+		}
+	}
+	return outputs
 }
 
-func NewCompileFileHandler(tools []executor.Tool) *CompileFileHandler {
-	return &CompileFileHandler{Tools: tools}
+// writeResult stores the result of the task and notifies the waiting clients.
+func (h CompileFileHandler) writeResult(ctx context.Context, t *asynq.Task, payload []byte) error {
+	if _, err := t.ResultWriter().Write(payload); err != nil {
+		return err
+	}
+
+	if h.Redis != nil {
+		if err := h.Redis.Publish(ctx, CompletionChannel(t.ResultWriter().TaskID()), payload).Err(); err != nil {
+			glog.Warningf("%s: could not publish result: %v", t.ResultWriter().TaskID(), err)
+		}
+	}
+	return nil
 }
 
-func respondError(t *asynq.Task, err error) error {
+// respondError fails the task, which is archived without a result, so it is
+// never cached. The waiting clients are notified right away and compile the
+// file locally.
+func (h CompileFileHandler) respondError(ctx context.Context, t *asynq.Task, err error) error {
 	glog.Errorf("error: %v", err)
-	responsePayload, err := json.Marshal(Response{
+	responsePayload, marshalErr := json.Marshal(Response{
 		ReturnCode: -1,
-		Stdout:     "",
-		Stderr:     fmt.Sprintf("%v", err),
 		Files:      make([]File, 0),
+		Error:      err.Error(),
 	})
-	if err != nil {
-		panic(err)
+	if marshalErr != nil {
+		panic(marshalErr)
 	}
 
-	t.ResultWriter().Write(responsePayload)
-	return nil
+	if h.Redis != nil {
+		// the task context may be done already
+		if err := h.Redis.Publish(context.Background(), CompletionChannel(t.ResultWriter().TaskID()), responsePayload).Err(); err != nil {
+			glog.Warningf("%s: could not publish failure: %v", t.ResultWriter().TaskID(), err)
+		}
+	}
+	return fmt.Errorf("%v: %w", err, asynq.SkipRetry)
 }
 
 func (h CompileFileHandler) ProcessTask(ctx context.Context, t *asynq.Task) error {
@@ -128,7 +314,7 @@ func (h CompileFileHandler) ProcessTask(ctx context.Context, t *asynq.Task) erro
 
 	tool, ok := utils.Find(h.Tools, func(tool executor.Tool) bool { return tool.Tag == p.Tag })
 	if !ok {
-		return respondError(t, fmt.Errorf("%s could not find tool: %s", t.ResultWriter().TaskID(), p.Tag))
+		return h.respondError(ctx, t, fmt.Errorf("%s could not find tool: %s", t.ResultWriter().TaskID(), p.Tag))
 	}
 
 	glog.Infof("%s: incomming request in queue '%s' for '%s' ['%s'] with %d packed files",
@@ -140,104 +326,196 @@ func (h CompileFileHandler) ProcessTask(ctx context.Context, t *asynq.Task) erro
 
 	glog.V(2).Infof("%s, files: ['%s']", t.ResultWriter().TaskID(), strings.Join(utils.Map(p.Inputs, func(file File) string { return file.Path }), "', '"))
 
-	randomDirectory, err := os.MkdirTemp("", "all-build-*")
-	glog.V(3).Infof("%s: created temporary directory: %s", t.ResultWriter().TaskID(), randomDirectory)
+	inputSize := int64(0)
+	for _, file := range p.Inputs {
+		inputSize += file.Size
+	}
+
+	workspace, err := h.Workspaces.Acquire(ctx, inputSize)
 	if err != nil {
-		return respondError(t, fmt.Errorf("%s: could not create temporary directory: %v", t.ResultWriter().TaskID(), err))
+		return h.respondError(ctx, t, fmt.Errorf("%s: could not create workspace: %v", t.ResultWriter().TaskID(), err))
 	}
+	// the workspace is kept for debugging when the compilation does not succeed
+	failed := true
+	defer func() { workspace.Release(failed) }()
+
+	randomDirectory := workspace.Dir
+	glog.V(3).Infof("%s: created temporary directory: %s", t.ResultWriter().TaskID(), randomDirectory)
 
 	compilerInstance := compiler.NewCompiler(p.Compiler)
 	if compilerInstance == nil {
-		return respondError(t, fmt.Errorf("%s: unknown compiler: %s", t.ResultWriter().TaskID(), p.Compiler))
+		return h.respondError(ctx, t, fmt.Errorf("%s: unknown compiler: %s", t.ResultWriter().TaskID(), p.Compiler))
 	}
 
-	compilerInstance.Parse(p.Command)
-
-	glog.V(3).Infof("%s: command before remapping: %v", t.ResultWriter().TaskID(), compiler.GetCommand(compilerInstance))
-	compilerInstance.Chroot(randomDirectory)
-	glog.V(3).Infof("%s: command after remapping: %v", t.ResultWriter().TaskID(), compiler.GetCommand(compilerInstance))
+	// in the namespace the workspace is mounted at the root, so the relative
+	// paths are in the working directory of the client
+	namespace := h.Sandbox == executor.SandboxNamespace
+	workspacePath := func(path string) string {
+		if namespace && !filepath.IsAbs(path) {
+			path = filepath.Join(p.Cwd, path)
+		}
+		return filepath.Join(randomDirectory, path)
+	}
 
-	for _, file := range p.Inputs {
-		filePath := filepath.Join(randomDirectory, file.Path)
+	if err := h.writeInputs(ctx, t.ResultWriter().TaskID(), randomDirectory, p.Inputs, workspacePath, namespace); err != nil {
+		return h.respondError(ctx, t, err)
+	}
 
-		glog.V(3).Infof("%s: creating directory: %s", t.ResultWriter().TaskID(), filepath.Dir(filePath))
-		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
-			return respondError(t, fmt.Errorf("%s: could not create directory: %v", t.ResultWriter().TaskID(), err))
-		}
+	// the response files are read from the workspace and written again with the remapped paths
+	compilerInstance.SetWorkingDirectory(workspacePath("."))
+	compilerInstance.Parse(p.Command)
 
-		glog.V(3).Infof("%s: writing file: %s", t.ResultWriter().TaskID(), filePath)
-		if err := os.WriteFile(filePath, file.Content, 0644); err != nil {
-			return respondError(t, fmt.Errorf("%s: could not write file: %v", t.ResultWriter().TaskID(), err))
-		}
+	if !namespace {
+		glog.V(3).Infof("%s: command before remapping: %v", t.ResultWriter().TaskID(), compiler.GetCommand(compilerInstance))
+		compilerInstance.Chroot(randomDirectory)
+		glog.V(3).Infof("%s: command after remapping: %v", t.ResultWriter().TaskID(), compiler.GetCommand(compilerInstance))
 
-		glog.V(3).Infof("%s: chmod file: %s", t.ResultWriter().TaskID(), filePath)
-		if err := os.Chmod(filePath, os.FileMode(file.Chmod)); err != nil {
-			return respondError(t, fmt.Errorf("%s: could not chmod file: %v", t.ResultWriter().TaskID(), err))
+		if err := compilerInstance.WriteResponseFiles(); err != nil {
+			return h.respondError(ctx, t, fmt.Errorf("%s: %v", t.ResultWriter().TaskID(), err))
 		}
 	}
 
 	// Create output directories
 	for _, output := range p.Outputs {
-		if err := os.MkdirAll(filepath.Dir(filepath.Join(randomDirectory, output)), 0755); err != nil {
-			return respondError(t, fmt.Errorf("%s: could not create directory: %v", t.ResultWriter().TaskID(), err))
+		dir := filepath.Dir(workspacePath(output))
+		if isTree(output) {
+			dir = workspacePath(output)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return h.respondError(ctx, t, fmt.Errorf("%s: could not create directory: %v", t.ResultWriter().TaskID(), err))
 		}
 	}
 
-	glog.V(2).Infof("%s: running command: %s ['%s']", t.ResultWriter().TaskID(), tool.Executable, strings.Join(compiler.GetCommand(compilerInstance), "', '"))
-	glog.V(2).Infof("%s: requested outputs: %v", t.ResultWriter().TaskID(), p.Outputs)
-	cmd := exec.Command(tool.Executable, compiler.GetCommand(compilerInstance)...)
-	cmd.Dir = randomDirectory
-	//command.Env = append(os.Environ(), p.Environment...)
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return respondError(t, fmt.Errorf("%s: could not get stderr pipe: %v", t.ResultWriter().TaskID(), err))
+	clientPaths := utils.Map(p.Inputs, func(file File) string { return file.Path })
+	args := compiler.GetCommand(compilerInstance)
+	if h.Sandbox == executor.SandboxPrefixMap {
+		switch p.Compiler {
+		case compiler.GCCCompiler, compiler.ClangCompiler:
+			args = append(args, executor.PrefixMapArguments(randomDirectory, p.Cwd, clientPaths)...)
+		case compiler.ClangCLCompiler:
+			args = append(args, utils.Map(executor.PrefixMapArguments(randomDirectory, p.Cwd, clientPaths), func(arg string) string { return "/clang:" + arg })...)
+		}
 	}
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return respondError(t, fmt.Errorf("%s: could not get stdout pipe: %v", t.ResultWriter().TaskID(), err))
+	glog.V(2).Infof("%s: running command: %s ['%s']", t.ResultWriter().TaskID(), tool.Executable, strings.Join(args, "', '"))
+	glog.V(2).Infof("%s: requested outputs: %v", t.ResultWriter().TaskID(), p.Outputs)
+	var cmd *exec.Cmd
+	if namespace {
+		cmd, err = executor.SandboxCommand(ctx, randomDirectory, p.Cwd, tool.Executable, args)
+		if err != nil {
+			return h.respondError(ctx, t, fmt.Errorf("%s: %v", t.ResultWriter().TaskID(), err))
+		}
+	} else {
+		cmd = exec.CommandContext(ctx, tool.Executable, args...)
+		cmd.Dir = randomDirectory
+		// the temporary files count into the workspace quota
+		cmd.Env = append(os.Environ(), "TMPDIR="+workspace.Temp, "TMP="+workspace.Temp, "TEMP="+workspace.Temp)
 	}
+	// a cancelled task kills the whole process group, not only the compiler driver
+	killProcessGroup(cmd)
+	//command.Env = append(os.Environ(), p.Environment...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
 
 	if err := cmd.Start(); err != nil {
-		return respondError(t, fmt.Errorf("%s: could not start command: %v", t.ResultWriter().TaskID(), err))
+		return h.respondError(ctx, t, fmt.Errorf("%s: could not start command: %v", t.ResultWriter().TaskID(), err))
+	}
+
+	measured := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(measureInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-measured:
+				return
+			case <-ticker.C:
+				workspace.Measure()
+			}
+		}
+	}()
+	cmd.Wait()
+	close(measured)
+	workspace.Measure()
+
+	if namespace {
+		if err := executor.RemoveSandbox(randomDirectory); err != nil {
+			glog.Warningf("%s: could not remove sandbox: %v", t.ResultWriter().TaskID(), err)
+		}
 	}
 
-	errout, _ := io.ReadAll(stderr)
-	out, _ := io.ReadAll(stdout)
+	errout := stderr.Bytes()
+	out := stdout.Bytes()
 
 	glog.V(1).Infof("%s: stderr: %s", t.ResultWriter().TaskID(), errout)
 	glog.V(1).Infof("%s: stdout: %s", t.ResultWriter().TaskID(), out)
 
-	cmd.Wait()
+	if ctx.Err() != nil {
+		glog.Infof("%s: cancelled: %v", t.ResultWriter().TaskID(), ctx.Err())
+		failed = false
+		return ctx.Err()
+	}
 
-	fsContent := walkFilesystem(cmd.Dir)
+	fsContent := walkFilesystem(randomDirectory)
 	glog.V(3).Infof("%s: filesystem content: ['%s']", t.ResultWriter().TaskID(), strings.Join(fsContent, "', '"))
 
+	pathMap := compiler.NewPathMap(randomDirectory, append(clientPaths, p.Outputs...))
+	depfile := compilerInstance.Invocation().Depfile
+
+	outputs := p.Outputs
+	if h.CollectOutputs {
+		collected := newOutputs(p, workspacePath)
+		glog.V(2).Infof("%s: collected outputs: %v", t.ResultWriter().TaskID(), collected)
+		outputs = append(append([]string{}, p.Outputs...), collected...)
+	}
+
 	outFiles := make([]File, 0)
-	for _, output := range p.Outputs {
-		content, err := os.ReadFile(filepath.Join(randomDirectory, output))
-		if err != nil {
-			glog.Warningf("%s: could not read output file: %v", t.ResultWriter().TaskID(), err)
+	for _, output := range outputs {
+		if isTree(output) {
+			content, err := packTree(workspacePath(output))
+			if err != nil {
+				glog.Warningf("%s: could not read output directory: %v", t.ResultWriter().TaskID(), err)
+				continue
+			}
+			outFiles = append(outFiles, File{Path: output, Tree: true, Content: content, Chmod: 0755})
 			continue
 		}
 
-		info, err := os.Stat(filepath.Join(randomDirectory, output))
+		file, err := readFile(workspacePath(output))
 		if err != nil {
-			glog.Warningf("%s: could not get file info: %v", t.ResultWriter().TaskID(), err)
+			glog.Warningf("%s: could not read output file: %v", t.ResultWriter().TaskID(), err)
 			continue
 		}
+		file.Path = output
+		// the outputs get the time they are written at on the client, a cached
+		// result must not look older than the inputs to the build system
+		file.Mtime = 0
+		file.Hash, file.Size = "", 0
+
+		// the links, the depfile and the diagnostics in SARIF have the workspace paths
+		switch {
+		case file.Link != "":
+			if rel, err := filepath.Rel(randomDirectory, file.Link); err == nil && filepath.IsAbs(file.Link) && filepath.IsLocal(rel) {
+				file.Link = string(filepath.Separator) + rel
+			}
+		case output == depfile || workspacePath(output) == depfile:
+			file.Content = pathMap.RewriteDepfile(file.Content)
+		case filepath.Ext(output) == ".sarif" || filepath.Ext(output) == ".json":
+			if rewritten, ok := pathMap.RewriteJSON(file.Content); ok {
+				file.Content = rewritten
+			}
+		}
 
-		outFiles = append(outFiles, File{
-			Path:    output,
-			Content: content,
-			Chmod:   int(info.Mode().Perm()),
-		})
+		outFiles = append(outFiles, file)
 	}
 
+	failed = cmd.ProcessState.ExitCode() != 0
+
 	reponse := Response{
 		ReturnCode: cmd.ProcessState.ExitCode(),
-		Stdout:     string(out),
-		Stderr:     string(errout),
+		Stdout:     pathMap.RewriteText(string(out)),
+		Stderr:     pathMap.RewriteText(string(errout)),
 		Files:      outFiles,
 	}
 	payload, err := json.Marshal(reponse)
@@ -245,7 +523,58 @@ func (h CompileFileHandler) ProcessTask(ctx context.Context, t *asynq.Task) erro
 		return err
 	}
 
-	t.ResultWriter().Write(payload)
+	return h.writeResult(ctx, t, payload)
+}
+
+// writeInputs writes the inputs of a task into the workspace dir. The paths
+// come from the client, so none may lead out of the workspace, neither by
+// itself nor through a link written before.
+func (h CompileFileHandler) writeInputs(ctx context.Context, id string, dir string, files []File, workspacePath func(string) string, namespace bool) error {
+	// the links are created last, so no input is written through them
+	inputs := make([]File, 0, len(files))
+	links := make([]File, 0)
+	for _, file := range files {
+		if file.Link != "" {
+			links = append(links, file)
+		} else {
+			inputs = append(inputs, file)
+		}
+	}
+
+	for _, file := range append(inputs, links...) {
+		filePath := workspacePath(file.Path)
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil || !filepath.IsLocal(rel) {
+			return fmt.Errorf("%s: input outside of the workspace: %s", id, file.Path)
+		}
+		if err := checkParents(dir, rel); err != nil {
+			return fmt.Errorf("%s: %v", id, err)
+		}
+
+		glog.V(3).Infof("%s: creating directory: %s", id, filepath.Dir(filePath))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return fmt.Errorf("%s: could not create directory: %v", id, err)
+		}
+
+		if file.Content == nil && file.Hash != "" {
+			if h.Blobs == nil {
+				return fmt.Errorf("%s: no blob cache to materialise %s", id, file.Path)
+			}
+			glog.V(3).Infof("%s: materialising file: %s (%s)", id, filePath, file.Hash)
+			if err := h.Blobs.Materialise(ctx, file.Hash, filePath, 0644); err != nil {
+				return fmt.Errorf("%s: could not materialise file: %v", id, err)
+			}
+		}
 
+		// an absolute link points to a client path, which is in the workspace
+		if filepath.IsAbs(file.Link) && !namespace {
+			file.Link = filepath.Join(dir, file.Link)
+		}
+
+		glog.V(3).Infof("%s: writing file: %s", id, filePath)
+		if err := WriteFile(filePath, file); err != nil {
+			return fmt.Errorf("%s: %v", id, err)
+		}
+	}
 	return nil
 }
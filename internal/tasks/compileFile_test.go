@@ -0,0 +1,62 @@
+package tasks
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestWriteInputs(t *testing.T) {
+	outside := t.TempDir()
+	var h CompileFileHandler
+
+	cases := []struct {
+		name   string
+		inputs []File
+		fails  bool
+	}{
+		{"regular", []File{{Path: "src/a.c", Chmod: 0644, Content: []byte("int")}, {Path: "inc", Link: "src"}}, false},
+		{"outside", []File{{Path: "../escape.h", Chmod: 0644, Content: []byte("int")}}, true},
+		{"through a link", []File{{Path: "inc", Link: outside}, {Path: "inc/escape.h", Chmod: 0644, Content: []byte("int")}}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			workspacePath := func(path string) string { return filepath.Join(dir, path) }
+			err := h.writeInputs(context.Background(), "id", dir, c.inputs, workspacePath, true)
+			if (err != nil) != c.fails {
+				t.Errorf("Expected failure %v, got %v", c.fails, err)
+			}
+		})
+	}
+
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("Expected nothing written outside of the workspace, got %v", entries)
+	}
+}
+
+func TestNewOutputs(t *testing.T) {
+	dir := t.TempDir()
+	workspacePath := func(path string) string { return filepath.Join(dir, path) }
+	for _, path := range []string{"out/a.c", "out/a.o", "out/a.gcno", "out/dumps/a.c.001t.tu", "out/crash/a.sh", "out/crash/a.c"} {
+		os.MkdirAll(filepath.Dir(workspacePath(path)), 0755)
+		os.WriteFile(workspacePath(path), nil, 0644)
+	}
+
+	p := CompileFile{
+		Inputs: []File{{Path: "out/a.c"}},
+		// the .dwo is not written and the directory of the .su does not exist
+		Outputs: []string{"out/a.o", "out/a.dwo", "missing/a.su", "out/crash/"},
+	}
+	outputs := newOutputs(p, workspacePath)
+	sort.Strings(outputs)
+
+	// the inputs, the requested outputs and the contents of the directory outputs are not collected
+	expected := []string{"out/a.gcno", "out/dumps/a.c.001t.tu"}
+	if !reflect.DeepEqual(outputs, expected) {
+		t.Errorf("Expected %v, got %v", expected, outputs)
+	}
+}
@@ -0,0 +1,114 @@
+package tasks
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Zeeno-atl/all-build/internal/blobs"
+)
+
+// readFile describes the file at path: the target of a symbolic link, which
+// is not followed, an empty directory or the content of a regular file.
+func readFile(path string) (File, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return File{}, err
+	}
+
+	file := File{Path: path, Chmod: int(info.Mode().Perm()), Mtime: info.ModTime().UnixNano()}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		file.Link, err = os.Readlink(path)
+	case info.IsDir():
+		file.Dir = true
+	default:
+		file.Content, err = os.ReadFile(path)
+		file.Hash = blobs.Hash(file.Content)
+		file.Size = int64(len(file.Content))
+	}
+	return file, err
+}
+
+// readInputs describes the inputs of a task. The targets of the symbolic links
+// are shipped too, so the links resolve in the workspace.
+func readInputs(paths []string) ([]File, error) {
+	files := make([]File, 0, len(paths))
+	seen := make(map[string]bool)
+	for len(paths) > 0 {
+		path := paths[0]
+		paths = paths[1:]
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		file, err := readFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read file: %v", err)
+		}
+		files = append(files, file)
+		if file.Link == "" {
+			continue
+		}
+
+		target := file.Link
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		if info, err := os.Stat(target); err != nil {
+			// a dangling link is shipped as it is
+			continue
+		} else if info.IsDir() {
+			paths = append(paths, walkFilesystem(target)...)
+		} else {
+			paths = append(paths, target)
+		}
+	}
+	return files, nil
+}
+
+// WriteFile writes a file of a task or a response at path: a symbolic link,
+// a directory or the content of a regular file. The content of a file only
+// referenced by its hash is written by the caller beforehand.
+func WriteFile(path string, file File) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("could not create directory: %v", err)
+	}
+
+	// a link is replaced, not written through
+	if info, err := os.Lstat(path); err == nil && (file.Link != "" || info.Mode()&os.ModeSymlink != 0) {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("could not replace %s: %v", path, err)
+		}
+	}
+
+	switch {
+	case file.Link != "":
+		if err := os.Symlink(file.Link, path); err != nil {
+			return fmt.Errorf("could not create link: %v", err)
+		}
+		// the mode and times of a link are the ones of its target
+		return nil
+	case file.Dir:
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return fmt.Errorf("could not create directory: %v", err)
+		}
+	case file.Content != nil || file.Hash == "":
+		if err := os.WriteFile(path, file.Content, 0644); err != nil {
+			return fmt.Errorf("could not write file: %v", err)
+		}
+	}
+
+	if err := os.Chmod(path, os.FileMode(file.Chmod)); err != nil {
+		return fmt.Errorf("could not chmod file: %v", err)
+	}
+	if file.Mtime != 0 {
+		mtime := time.Unix(0, file.Mtime)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			return fmt.Errorf("could not set file times: %v", err)
+		}
+	}
+	return nil
+}
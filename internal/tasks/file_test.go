@@ -0,0 +1,81 @@
+package tasks
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadInputs(t *testing.T) {
+	dir := t.TempDir()
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	os.MkdirAll(filepath.Join(dir, "conan", "include"), 0755)
+	os.MkdirAll(filepath.Join(dir, "empty"), 0755)
+	os.WriteFile(filepath.Join(dir, "conan", "include", "h.h"), []byte("int"), 0644)
+	os.Chtimes(filepath.Join(dir, "conan", "include", "h.h"), modified, modified)
+	os.Symlink("conan/include/h.h", filepath.Join(dir, "h.h"))
+	os.Symlink("conan", filepath.Join(dir, "linked"))
+
+	files, err := readInputs([]string{filepath.Join(dir, "h.h"), filepath.Join(dir, "linked"), filepath.Join(dir, "empty")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byPath := make(map[string]File)
+	for _, file := range files {
+		rel, _ := filepath.Rel(dir, file.Path)
+		byPath[rel] = file
+	}
+
+	if file := byPath["h.h"]; file.Link != "conan/include/h.h" || file.Content != nil {
+		t.Errorf("Expected h.h to be a link, got %+v", file)
+	}
+	if file := byPath["linked"]; file.Link != "conan" {
+		t.Errorf("Expected linked to be a link, got %+v", file)
+	}
+	// the targets of the links are shipped too
+	if file, ok := byPath["conan/include/h.h"]; !ok || string(file.Content) != "int" || file.Mtime != modified.UnixNano() {
+		t.Errorf("Expected the target of the link with its time, got %+v", file)
+	}
+	if file := byPath["empty"]; !file.Dir {
+		t.Errorf("Expected the empty directory, got %+v", file)
+	}
+
+	if _, err := readInputs([]string{filepath.Join(dir, "missing.h")}); err == nil {
+		t.Errorf("Expected a missing input to fail")
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	files := []File{
+		{Path: "inc/h.h", Chmod: 0640, Content: []byte("int"), Mtime: modified.UnixNano()},
+		{Path: "h.h", Link: "inc/h.h"},
+		{Path: "empty", Chmod: 0755, Dir: true, Mtime: modified.UnixNano()},
+	}
+	for _, file := range files {
+		if err := WriteFile(filepath.Join(dir, file.Path), file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if info, err := os.Stat(filepath.Join(dir, "inc", "h.h")); err != nil || info.Mode().Perm() != 0640 || !info.ModTime().Equal(modified) {
+		t.Errorf("Expected inc/h.h with its mode and time: %v %v", info, err)
+	}
+	if link, err := os.Readlink(filepath.Join(dir, "h.h")); err != nil || link != "inc/h.h" {
+		t.Errorf("Expected h.h to link to inc/h.h, got %q: %v", link, err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "empty")); err != nil || !info.IsDir() || !info.ModTime().Equal(modified) {
+		t.Errorf("Expected the empty directory with its time: %v %v", info, err)
+	}
+
+	// a link is replaced, its target is not overwritten
+	if err := WriteFile(filepath.Join(dir, "h.h"), File{Path: "h.h", Chmod: 0644, Content: []byte("long")}); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "inc", "h.h")); string(content) != "int" {
+		t.Errorf("Expected the target of the replaced link to be kept, got %q", content)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package tasks
+
+import (
+	"os/exec"
+	"time"
+)
+
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.WaitDelay = 5 * time.Second
+}
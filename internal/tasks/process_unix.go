@@ -0,0 +1,22 @@
+//go:build unix
+
+package tasks
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// killProcessGroup runs the command in its own process group and makes the
+// cancellation kill the group, so compiler subprocesses (cc1plus, as, ld) die too.
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 5 * time.Second
+}
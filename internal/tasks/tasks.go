@@ -5,10 +5,20 @@ const (
 	TypeCompileFile = "compile"
 )
 
+// File is either sent inline in Content, or only referenced by its Hash,
+// in which case the content is in the shared blob store. A Tree is a directory
+// output, its Content is a tar archive of the directory. A Link is a symbolic
+// link to its target and a Dir an empty directory, both without content.
 type File struct {
 	Path    string `json:"path"`
 	Chmod   int    `json:"chmod"`
 	Content []byte `json:"content"`
+	Hash    string `json:"hash,omitempty"`
+	Size    int64  `json:"size,omitempty"`
+	Tree    bool   `json:"tree,omitempty"`
+	Link    string `json:"link,omitempty"`
+	Dir     bool   `json:"dir,omitempty"`
+	Mtime   int64  `json:"mtime,omitempty"` // in nanoseconds since the epoch
 }
 
 type Response struct {
@@ -16,4 +26,19 @@ type Response struct {
 	Stdout     string `json:"stdout"`
 	Stderr     string `json:"stderr"`
 	Files      []File `json:"files"`
+	// Error is set when the executor could not compile the file, e.g. the tool
+	// or an input is missing, and the client compiles it locally
+	Error string `json:"error,omitempty"`
+}
+
+// CompletionChannel is where the executor publishes the Response of a task,
+// so the clients do not need to poll for it.
+func CompletionChannel(taskID string) string {
+	return "all-build:completed:" + taskID
+}
+
+// ToolKey is where the executors publish the fingerprint of the tool of a tag,
+// which is a part of the action hash.
+func ToolKey(tag string) string {
+	return "all-build:tool:" + tag
 }
@@ -0,0 +1,222 @@
+package tasks
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// isTree tells whether an output names a directory, which is written with a
+// trailing separator.
+func isTree(output string) bool {
+	return strings.HasSuffix(output, "/") || strings.HasSuffix(output, string(filepath.Separator))
+}
+
+// packTree packs a directory output into a tar archive, keeping the file
+// modes, the symbolic links, the empty directories and the modification times.
+func packTree(dir string) ([]byte, error) {
+	var buffer bytes.Buffer
+	archive := tar.NewWriter(&buffer)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			if _, err := io.Copy(archive, file); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not pack %s: %v", dir, err)
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// WriteTree materialises a directory output packed by the executor. The
+// entries are merged into the directory, the files already in it are kept,
+// and every file is replaced at once, so readers never see a partial file.
+func WriteTree(dir string, content []byte, chmod os.FileMode) error {
+	dir = filepath.Clean(dir)
+	if _, err := os.Lstat(dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if err := os.Chmod(dir, chmod); err != nil {
+			return err
+		}
+	}
+
+	if err := unpackTree(content, dir); err != nil {
+		return fmt.Errorf("could not unpack %s: %v", dir, err)
+	}
+	return nil
+}
+
+// checkParents fails when a path of the archive passes through a symbolic
+// link, which could point out of the directory.
+func checkParents(dir string, name string) error {
+	parent := dir
+	for _, part := range strings.Split(filepath.Dir(filepath.FromSlash(name)), string(filepath.Separator)) {
+		if part == "." {
+			continue
+		}
+		parent = filepath.Join(parent, part)
+		info, err := os.Lstat(parent)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("path through a symbolic link: %s", name)
+		}
+	}
+	return nil
+}
+
+func unpackTree(content []byte, dir string) error {
+	archive := tar.NewReader(bytes.NewReader(content))
+	// the directories get their times when nothing is written into them anymore
+	times := make(map[string]time.Time)
+	// the links are created last, so no entry is written through them
+	links := make([]*tar.Header, 0)
+
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !filepath.IsLocal(header.Name) {
+			return fmt.Errorf("path outside of the directory: %s", header.Name)
+		}
+		if err := checkParents(dir, header.Name); err != nil {
+			return err
+		}
+		path := filepath.Join(dir, header.Name)
+		mode := os.FileMode(header.Mode).Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+				return fmt.Errorf("directory replaced by a symbolic link: %s", header.Name)
+			}
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			if err := os.Chmod(path, mode|0700); err != nil {
+				return err
+			}
+			times[path] = header.ModTime
+		case tar.TypeSymlink:
+			links = append(links, header)
+		case tar.TypeReg:
+			if err := writeAtomically(path, archive, mode, header.ModTime); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported entry: %s", header.Name)
+		}
+	}
+
+	for _, header := range links {
+		if err := checkParents(dir, header.Name); err != nil {
+			return err
+		}
+		if err := linkAtomically(header.Linkname, filepath.Join(dir, header.Name)); err != nil {
+			return err
+		}
+	}
+
+	for path, modified := range times {
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeAtomically writes a file next to path and renames it over path, which
+// replaces a link instead of writing through it.
+func writeAtomically(path string, content io.Reader, mode os.FileMode, modified time.Time) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = io.Copy(file, content)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	// the umask reduces the mode of a new file
+	if err := os.Chmod(file.Name(), mode); err != nil {
+		return err
+	}
+	if err := os.Chtimes(file.Name(), modified, modified); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// linkAtomically creates a symbolic link next to path and renames it over path.
+func linkAtomically(target string, path string) error {
+	temporary, err := os.MkdirTemp(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(temporary)
+
+	link := filepath.Join(temporary, "link")
+	if err := os.Symlink(target, link); err != nil {
+		return err
+	}
+	return os.Rename(link, path)
+}
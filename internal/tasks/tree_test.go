@@ -0,0 +1,85 @@
+package tasks
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTreeRoundTrip(t *testing.T) {
+	source := t.TempDir()
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	os.MkdirAll(filepath.Join(source, "sub", "empty"), 0755)
+	os.WriteFile(filepath.Join(source, "sub", "a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(source, "run.sh"), []byte("#!/bin/sh"), 0777)
+	os.Chmod(filepath.Join(source, "run.sh"), 0777)
+	os.Symlink("sub/a.txt", filepath.Join(source, "link"))
+	for _, path := range []string{"sub/a.txt", "run.sh", "sub/empty", "sub"} {
+		os.Chtimes(filepath.Join(source, path), modified, modified)
+	}
+
+	content, err := packTree(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the tree is merged into the directory, a link in it is replaced
+	dir := filepath.Join(t.TempDir(), "out")
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "other.o"), []byte("other"), 0644)
+	os.WriteFile(filepath.Join(dir, "target.sh"), []byte("target"), 0644)
+	os.Symlink("target.sh", filepath.Join(dir, "run.sh"))
+	if err := WriteTree(dir+"/", content, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(dir, "other.o")); string(data) != "other" {
+		t.Errorf("Expected the files already in the directory to be kept, got %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "target.sh")); string(data) != "target" {
+		t.Errorf("Expected the target of the replaced link to be kept, got %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "sub", "a.txt")); string(data) != "a" {
+		t.Errorf("Expected sub/a.txt to contain a, got %q", data)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "run.sh")); err != nil || info.Mode().Perm() != 0777 {
+		t.Errorf("Expected run.sh to keep its mode despite the umask: %v %v", info, err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "sub", "empty")); err != nil || !info.IsDir() {
+		t.Errorf("Expected the empty directory to be kept: %v", err)
+	}
+	if link, err := os.Readlink(filepath.Join(dir, "link")); err != nil || link != "sub/a.txt" {
+		t.Errorf("Expected the link to sub/a.txt, got %q: %v", link, err)
+	}
+	for _, path := range []string{"sub/a.txt", "run.sh", "sub/empty", "sub"} {
+		if info, err := os.Stat(filepath.Join(dir, path)); err != nil || !info.ModTime().Equal(modified) {
+			t.Errorf("Expected %s to keep its time, got %v: %v", path, info.ModTime(), err)
+		}
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 5 {
+		t.Errorf("Expected no temporary files to be left, got %d entries", len(entries))
+	}
+}
+
+func TestTreeThroughLink(t *testing.T) {
+	outside := t.TempDir()
+	var buffer bytes.Buffer
+	archive := tar.NewWriter(&buffer)
+	archive.WriteHeader(&tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: outside, Mode: 0777})
+	archive.WriteHeader(&tar.Header{Name: "a/pwned", Typeflag: tar.TypeReg, Mode: 0644, Size: 5})
+	archive.Write([]byte("pwned"))
+	archive.Close()
+
+	dir := filepath.Join(t.TempDir(), "out")
+	if err := WriteTree(dir, buffer.Bytes(), 0755); err == nil {
+		t.Errorf("Expected an entry through a link of the archive to fail")
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "pwned")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be written out of the directory")
+	}
+}
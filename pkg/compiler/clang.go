@@ -0,0 +1,51 @@
+package compiler
+
+// clangOptions are the options of the clang driver on top of the GCC ones.
+func clangOptions() []option {
+	return []option{
+		// Target
+		{name: "--target", value: valueEquals},
+		{name: "-target", value: valueSeparate},
+		{name: "--gcc-toolchain", value: valueSeparate | valueEquals, argType: ArgumentTypeInput},
+		{name: "-gcc-toolchain", value: valueSeparate, argType: ArgumentTypeInput},
+		{name: "-resource-dir", value: valueSeparate | valueEquals, argType: ArgumentTypeInput},
+		{name: "-isystem-after", value: valueJoined | valueSeparate, argType: ArgumentTypeInput},
+
+		// Pass-through to the compiler front-end
+		{name: "-Xclang", value: valueSeparate},
+		{name: "-Xarch_host", value: valueSeparate},
+		{name: "-Xarch_device", value: valueSeparate},
+		{name: "-mllvm", value: valueSeparate},
+
+		// Files used by the compilation
+		{name: "-fmodules-cache-path", value: valueEquals, argType: ArgumentTypeInput},
+		{name: "-fprofile-instr-use", value: valueEquals, argType: ArgumentTypeInput},
+		{name: "-fprofile-sample-use", value: valueEquals, argType: ArgumentTypeInput},
+		{name: "-fsanitize-ignorelist", value: valueEquals, argType: ArgumentTypeInput},
+		{name: "-fsanitize-blacklist", value: valueEquals, argType: ArgumentTypeInput},
+		{name: "-fprofile-list", value: valueEquals, argType: ArgumentTypeInput},
+		{name: "-ivfsoverlay", value: valueJoined | valueSeparate, argType: ArgumentTypeInput},
+		{name: "-include-pch", value: valueSeparate, argType: ArgumentTypeInput},
+		{name: "-ftime-trace", value: valueEquals, argType: ArgumentTypeOutput},
+		{name: "-fcrash-diagnostics-dir", value: valueEquals, argType: ArgumentTypeOutput, tree: true},
+
+		// C++20 modules
+		{name: "-fmodule-file", value: valueEquals, argType: ArgumentTypeInput, keyed: true},
+		{name: "-fprebuilt-module-path", value: valueEquals, argType: ArgumentTypeInput},
+		{name: "-fmodule-map-file", value: valueEquals, argType: ArgumentTypeInput},
+		{name: "-fmodule-output", value: valueEquals, argType: ArgumentTypeOutput},
+		{name: "-fmodule-name", value: valueEquals},
+	}
+}
+
+type Clang struct {
+	GCC
+}
+
+func (c *Clang) Parse(args []string) error {
+	if err := c.parse(args, newOptionTable(gccOptions(), clangOptions())); err != nil {
+		return err
+	}
+	c.addModuleFiles()
+	return nil
+}
@@ -0,0 +1,24 @@
+package compiler
+
+// clangCLOptions are the options clang-cl adds to the MSVC ones.
+func clangCLOptions() []option {
+	return withMSVCPrefixes([]option{
+		{name: "imsvc", value: valueJoined | valueSeparate, argType: ArgumentTypeInput},
+		{name: "winsysroot", value: valueJoined | valueSeparate, argType: ArgumentTypeInput},
+		{name: "winsdkdir", value: valueJoined | valueSeparate, argType: ArgumentTypeInput},
+		{name: "vctoolsdir", value: valueJoined | valueSeparate, argType: ArgumentTypeInput},
+		{name: "winsdkversion", value: valueJoined | valueSeparate},
+		{name: "vctoolsversion", value: valueJoined | valueSeparate},
+		{name: "clang:", value: valueJoined},
+	})
+}
+
+// ClangCL is the MSVC compatible driver of clang. It understands the MSVC
+// options and the clang ones starting with "-", e.g. -Xclang or --target.
+type ClangCL struct {
+	MSVC
+}
+
+func (c *ClangCL) Parse(args []string) error {
+	return c.parse(args, newOptionTable(msvcOptions(), clangCLOptions(), clangOptions()))
+}
@@ -0,0 +1,37 @@
+package compiler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var clangCLCorpus = []corpusCase{
+	{"msvc options", []string{"/c", "/Iinc", "/DFOO", "a.cpp", "/Fo:a.obj"}, []string{"inc", "a.cpp"}, []string{"a.obj"}},
+	{"imsvc joined", []string{"/c", "/imsvcsdk/include", "a.cpp"}, []string{"sdk/include", "a.cpp"}, []string{"a.obj"}},
+	{"imsvc separate", []string{"/c", "-imsvc", "/opt/msvc/include", "a.cpp"}, []string{"/opt/msvc/include", "a.cpp"}, []string{"a.obj"}},
+	{"winsysroot", []string{"/c", "/winsysroot", "/opt/winsysroot", "a.cpp"}, []string{"/opt/winsysroot", "a.cpp"}, []string{"a.obj"}},
+	{"winsdk", []string{"/c", "/winsdkdir", "sdk", "/winsdkversion", "10.0.22621.0", "/vctoolsdir", "vc", "a.cpp"}, []string{"sdk", "vc", "a.cpp"}, []string{"a.obj"}},
+	{"xclang", []string{"/c", "-Xclang", "-fno-pch-timestamp", "a.cpp"}, []string{"a.cpp"}, []string{"a.obj"}},
+	{"clang option", []string{"/c", "/clang:-fno-exceptions", "a.cpp"}, []string{"a.cpp"}, []string{"a.obj"}},
+	{"target", []string{"/c", "--target=x86_64-pc-windows-msvc", "-fuse-ld=lld", "a.cpp"}, []string{"a.cpp"}, []string{"a.obj"}},
+	{"clang files", []string{"/c", "-fprofile-instr-use=a.profdata", "-fsanitize-ignorelist=ignore.txt", "a.cpp"}, []string{"a.profdata", "ignore.txt", "a.cpp"}, []string{"a.obj"}},
+	{"link", []string{"a.cpp", "-fuse-ld=lld", "/link", "/OUT:app.exe"}, []string{"a.cpp"}, []string{"app.exe", "a.obj"}},
+	{"pdb directory", []string{"/nologo", "/c", "/Z7", "/FdCMakeFiles/foo.dir/", "/FoCMakeFiles/foo.dir/a.cpp.obj", "a.cpp"}, []string{"a.cpp"}, []string{"CMakeFiles/foo.dir/a.cpp.obj"}},
+}
+
+func TestClangCLCorpus(t *testing.T) {
+	runCorpus(t, ClangCLCompiler, clangCLCorpus)
+}
+
+func TestClangCLChroot(t *testing.T) {
+	clangCL := NewCompiler(ClangCLCompiler)
+	clangCL.Parse([]string{"/c", "/winsysroot", "/opt/winsysroot", "-imsvc", "inc", "-Xclang", "-fno-pch-timestamp", "a.cpp", "/Fo:a.obj"})
+	clangCL.Chroot("/tmp/root")
+
+	expected := []string{"/c", "/winsysroot", "/tmp/root/opt/winsysroot", "-imsvc", "/tmp/root/inc", "-Xclang", "-fno-pch-timestamp", "/tmp/root/a.cpp", "/Fo:/tmp/root/a.obj"}
+	command := GetCommand(clangCL)
+	if !reflect.DeepEqual(command, expected) {
+		t.Errorf("Expected command ['%s'], got ['%s']", strings.Join(expected, "', '"), strings.Join(command, "', '"))
+	}
+}
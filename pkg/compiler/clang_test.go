@@ -0,0 +1,45 @@
+package compiler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var clangCorpus = []corpusCase{
+	{"target equals", []string{"-c", "--target=aarch64-linux-gnu", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"target separate", []string{"-c", "-target", "x86_64-pc-windows-msvc", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"xclang", []string{"-c", "-Xclang", "-fno-pch-timestamp", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"xclang plugin", []string{"-c", "-Xclang", "-load", "-Xclang", "plugin.so", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"mllvm", []string{"-c", "-mllvm", "-inline-threshold=100", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"resource dir separate", []string{"-c", "-resource-dir", "res", "a.c", "-o", "a.o"}, []string{"res", "a.c"}, []string{"a.o"}},
+	{"resource dir equals", []string{"-c", "-resource-dir=res", "a.c", "-o", "a.o"}, []string{"res", "a.c"}, []string{"a.o"}},
+	{"modules cache", []string{"-c", "-fmodules", "-fmodules-cache-path=cache", "a.c", "-o", "a.o"}, []string{"cache", "a.c"}, []string{"a.o"}},
+	{"profile instr use", []string{"-c", "-fprofile-instr-use=a.profdata", "a.c", "-o", "a.o"}, []string{"a.profdata", "a.c"}, []string{"a.o"}},
+	{"profile instr use flag", []string{"-c", "-fprofile-instr-use", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"sanitize ignorelist", []string{"-c", "-fsanitize=address", "-fsanitize-ignorelist=ignore.txt", "a.c", "-o", "a.o"}, []string{"ignore.txt", "a.c"}, []string{"a.o"}},
+	{"gcc toolchain equals", []string{"-c", "--gcc-toolchain=/opt/gcc", "a.c", "-o", "a.o"}, []string{"/opt/gcc", "a.c"}, []string{"a.o"}},
+	{"gcc toolchain separate", []string{"-c", "--gcc-toolchain", "/opt/gcc", "a.c", "-o", "a.o"}, []string{"/opt/gcc", "a.c"}, []string{"a.o"}},
+	{"time trace", []string{"-c", "-ftime-trace", "a.c", "-o", "out/a.o"}, []string{"a.c"}, []string{"out/a.o", "out/a.json"}},
+	{"time trace file", []string{"-c", "-ftime-trace=trace.json", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"trace.json", "a.o"}},
+	{"time trace directory", []string{"-c", "-ftime-trace=traces/", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o", "traces/a.json"}},
+	{"crash diagnostics", []string{"-c", "-fcrash-diagnostics-dir=crash/", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"crash/", "a.o"}},
+	{"isystem after", []string{"-c", "-isystem-after", "foo", "-isystem-afterbar", "a.c", "-o", "a.o"}, []string{"foo", "bar", "a.c"}, []string{"a.o"}},
+	{"gcc options", []string{"-c", "-I", "inc", "-isystem", "sys", "-MD", "-MF", "a.d", "a.c", "-o", "a.o"}, []string{"inc", "sys", "a.c"}, []string{"a.d", "a.o"}},
+}
+
+func TestClangCorpus(t *testing.T) {
+	runCorpus(t, ClangCompiler, clangCorpus)
+}
+
+func TestClangChroot(t *testing.T) {
+	clang := NewCompiler(ClangCompiler)
+	clang.Parse([]string{"-c", "--target=x86_64-linux-gnu", "-Xclang", "-fno-pch-timestamp", "-resource-dir", "res", "-fprofile-instr-use=a.profdata", "--gcc-toolchain=/opt/gcc", "a.c", "-o", "a.o"})
+	clang.Chroot("/tmp/root")
+
+	expected := []string{"-c", "--target=x86_64-linux-gnu", "-Xclang", "-fno-pch-timestamp", "-resource-dir", "/tmp/root/res", "-fprofile-instr-use=/tmp/root/a.profdata", "--gcc-toolchain=/tmp/root/opt/gcc", "/tmp/root/a.c", "-o", "/tmp/root/a.o"}
+	command := GetCommand(clang)
+	if !reflect.DeepEqual(command, expected) {
+		t.Errorf("Expected command ['%s'], got ['%s']", strings.Join(expected, "', '"), strings.Join(command, "', '"))
+	}
+}
@@ -19,18 +19,26 @@ type IArgument interface {
 	Parameter() string
 
 	Chroot(path string)
+	Tokens() []string     // the argument as passed on the command line
+	ResponseFile() string // the response file the argument was read from, "" for the command line
 	Stringify() string
 }
 
 type ICompiler interface {
-	Parse(args []string) error
+	SetWorkingDirectory(dir string) // response files are read relative to it, the current one by default
+	Parse(args []string) error      // expands the response files
 	Arguments() []IArgument
-	Chroot(path string) // Does mapping of folders
+	Invocation() Invocation
+	// Preprocess returns the commands preprocessing the source locally into
+	// output and compiling the preprocessed output remotely
+	Preprocess(output string, directivesOnly bool) (local []string, remote []string, err error)
+	Chroot(path string)        // Does mapping of folders
+	WriteResponseFiles() error // writes the response files again with the chrooted arguments
 }
 
 func GetInputs(c ICompiler) []string {
 	inputs := utils.Map(utils.Filter(c.Arguments(), func(arg IArgument) bool {
-		return arg.IsInput() || arg.Command() == ""
+		return arg.IsInput() || (arg.Command() == "" && !arg.IsOutput())
 	}), func(arg IArgument) string {
 		return arg.Parameter()
 	})
@@ -42,6 +50,16 @@ func GetInputs(c ICompiler) []string {
 	return inputs
 }
 
+// GetResponseFiles returns the @file arguments, the files are inputs but
+// their directories are not.
+func GetResponseFiles(c ICompiler) []string {
+	return utils.Map(utils.Filter(c.Arguments(), func(arg IArgument) bool {
+		return arg.Command() == responseCommand
+	}), func(arg IArgument) string {
+		return arg.Parameter()
+	})
+}
+
 func GetOutputs(c ICompiler) []string {
 	outputs := utils.Map(utils.Filter(c.Arguments(), func(arg IArgument) bool {
 		return arg.IsOutput()
@@ -59,14 +77,18 @@ func GetOutputs(c ICompiler) []string {
 func GetCommand(c ICompiler) []string {
 	command := make([]string, 0)
 	for _, arg := range c.Arguments() {
-		command = append(command, arg.Stringify())
+		if arg.ResponseFile() == "" {
+			command = append(command, arg.Tokens()...)
+		}
 	}
 	return command
 }
 
 const (
-	MSVCCompiler = "msvc"
-	GCCCompiler  = "gcc"
+	MSVCCompiler    = "msvc"
+	GCCCompiler     = "gcc"
+	ClangCompiler   = "clang"
+	ClangCLCompiler = "clang-cl"
 )
 
 func NewCompiler(compiler string) ICompiler {
@@ -75,6 +97,10 @@ func NewCompiler(compiler string) ICompiler {
 		return &MSVC{}
 	case GCCCompiler:
 		return &GCC{}
+	case ClangCompiler:
+		return &Clang{}
+	case ClangCLCompiler:
+		return &ClangCL{}
 	default:
 		return nil
 	}
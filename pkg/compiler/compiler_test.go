@@ -1,10 +1,50 @@
 package compiler
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 )
 
+// corpusCase is a command line with the inputs and outputs the compiler finds in it.
+type corpusCase struct {
+	name    string
+	args    []string
+	inputs  []string
+	outputs []string
+}
+
+// nonNil makes a missing list equal to an empty one.
+func nonNil(paths []string) []string {
+	if paths == nil {
+		return []string{}
+	}
+	return paths
+}
+
+// runCorpus parses every command line of the corpus, checks its inputs and
+// outputs and that the command is given back unchanged.
+func runCorpus(t *testing.T, compilerType string, corpus []corpusCase) {
+	for _, tc := range corpus {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewCompiler(compilerType)
+			if err := c.Parse(tc.args); err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+
+			if inputs := GetInputs(c); !reflect.DeepEqual(nonNil(inputs), nonNil(tc.inputs)) {
+				t.Errorf("Expected inputs ['%s'], got ['%s']", strings.Join(tc.inputs, "', '"), strings.Join(inputs, "', '"))
+			}
+			if outputs := GetOutputs(c); !reflect.DeepEqual(nonNil(outputs), nonNil(tc.outputs)) {
+				t.Errorf("Expected outputs ['%s'], got ['%s']", strings.Join(tc.outputs, "', '"), strings.Join(outputs, "', '"))
+			}
+			if command := GetCommand(c); !reflect.DeepEqual(command, tc.args) {
+				t.Errorf("Expected command ['%s'], got ['%s']", strings.Join(tc.args, "', '"), strings.Join(command, "', '"))
+			}
+		})
+	}
+}
+
 func TestCompilerGccGetIncludePaths(t *testing.T) {
 	gcc := NewCompiler(GCCCompiler)
 	if gcc == nil {
@@ -1,8 +1,8 @@
 package compiler
 
 import (
+	"os"
 	"path/filepath"
-	"sort"
 	"strings"
 
 	"github.com/Zeeno-atl/all-build/internal/utils"
@@ -14,9 +14,20 @@ type GCCArgument struct {
 	argType   string
 	command   string
 	parameter string
+	separate  bool   // the parameter was passed as a standalone argument
+	implicit  bool   // not on the command line, e.g. an output the compiler names on its own
+	response  string // the response file the argument was read from
+	key       string // the name= before the path of a keyed option
+	tree      bool   // a directory output, returned whole
 	basePath  string
 }
 
+// responseCommand is the command of a @file argument
+const responseCommand = "@"
+
+// options which only query the compiler
+var gccQueries = []string{"--version", "-dumpversion", "-dumpfullversion", "-dumpmachine", "-dumpspecs", "-###", "--target-help"}
+
 func (a *GCCArgument) IsInput() bool {
 	return a.Type() == ArgumentTypeInput
 }
@@ -34,50 +45,51 @@ func (a *GCCArgument) Command() string {
 }
 
 func (a *GCCArgument) Parameter() string {
+	if a.IsOutput() && a.tree {
+		// a directory output keeps its separator
+		return filepath.Join(a.basePath, a.parameter) + "/"
+	}
 	if a.IsInput() || a.IsOutput() || a.command == "" {
 		return filepath.Join(a.basePath, a.parameter)
 	}
 	return a.parameter
 }
 
-func (a *GCCArgument) Stringify() string {
-	command := a.command
-	if a.parameter != "" && a.command != "" {
-		command += a.Parameter()
-	} else if a.parameter != "" {
-		command = a.Parameter()
+func (a *GCCArgument) ResponseFile() string {
+	return a.response
+}
+
+func (a *GCCArgument) Tokens() []string {
+	if a.implicit {
+		return []string{}
+	}
+	if a.command == "" {
+		return []string{a.Parameter()}
 	}
-	return command
+	if a.separate {
+		return []string{a.command, a.key + a.Parameter()}
+	}
+	if a.parameter != "" || a.key != "" {
+		return []string{a.command + a.key + a.Parameter()}
+	}
+	return []string{a.command}
 }
 
-func (a *GCCArgument) Chroot(path string) {
-	a.basePath = path
+func (a *GCCArgument) Stringify() string {
+	return strings.Join(a.Tokens(), " ")
 }
 
-func gccInputs() []string {
-	inputs := []string{"-isystem", "-I"}
-	// sort by length, so that we can match the longest first (consider prefixes "-isystem" and "-i")
-	sort.Slice(inputs, func(i int, j int) bool {
-		return len(inputs[i]) > len(inputs[j])
-	})
-	return inputs
+func (a *GCCArgument) Chroot(path string) {
+	a.basePath = path
 }
 
-func gccOutputs() []string {
-	outputs := []string{"-o"}
-	// see why we sort by length in gccInputs
-	sort.Slice(outputs, func(i int, j int) bool {
-		return len(outputs[i]) > len(outputs[j])
-	})
-	return outputs
+func isGCCOption(arg string) bool {
+	return strings.HasPrefix(arg, "-")
 }
 
-func parseArguments(args []string) []GCCArgument {
+func parseArguments(args []token, table *optionTable, isOption func(string) bool) []GCCArgument {
 	arguments := make([]GCCArgument, 0)
 
-	inputTypes := gccInputs()
-	outputTypes := gccOutputs()
-
 	const (
 		StateParsing = iota
 		StateGettingParameter
@@ -85,51 +97,53 @@ func parseArguments(args []string) []GCCArgument {
 
 	state := StateParsing
 	argument := GCCArgument{}
+	keyed := false
+	withKey := func(argument GCCArgument) GCCArgument {
+		if keyed {
+			if key, path, ok := strings.Cut(argument.parameter, "="); ok {
+				argument.key, argument.parameter = key+"=", path
+			}
+		}
+		return argument
+	}
 
-	for _, arg := range args {
+	for _, t := range args {
+		arg := t.value
 		if state == StateGettingParameter {
 			state = StateParsing
 			argument.parameter = arg
-			arguments = append(arguments, argument)
+			arguments = append(arguments, withKey(argument))
 			continue
 		}
 
-		// First, exact matches are looked for, so we the next arg is the path
-
-		if utils.Contains(inputTypes, arg) {
-			state = StateGettingParameter
-			argument = GCCArgument{command: arg, argType: ArgumentTypeInput}
+		if t.file != "" {
+			arguments = append(arguments, GCCArgument{command: responseCommand, argType: ArgumentTypeInput, parameter: t.file, response: t.response})
 			continue
 		}
 
-		if utils.Contains(outputTypes, arg) {
-			state = StateGettingParameter
-			argument = GCCArgument{command: arg, argType: ArgumentTypeOutput}
-			continue
-		}
-
-		// It is possible to not have a space between the argument and the parameter
-
-		if prefix, ok := utils.Prefix(arg, inputTypes); ok {
-			state = StateParsing
-			argument = GCCArgument{command: arg[:len(prefix)], argType: ArgumentTypeInput, parameter: arg[len(prefix):]}
-			arguments = append(arguments, argument)
+		option, command, value, separate, ok := table.match(arg)
+		if !ok {
+			if isOption(arg) {
+				arguments = append(arguments, GCCArgument{command: arg, response: t.response})
+			} else {
+				arguments = append(arguments, GCCArgument{parameter: arg, response: t.response})
+			}
 			continue
 		}
 
-		if prefix, ok := utils.Prefix(arg, outputTypes); ok {
-			state = StateParsing
-			argument = GCCArgument{command: arg[:len(prefix)], argType: ArgumentTypeOutput, parameter: arg[len(prefix):]}
-			arguments = append(arguments, argument)
+		argument = GCCArgument{command: command, argType: option.argType, parameter: value, separate: separate, response: t.response, tree: option.tree}
+		keyed = option.keyed
+		if separate {
+			// the next arg is the parameter
+			state = StateGettingParameter
 			continue
 		}
+		arguments = append(arguments, withKey(argument))
+	}
 
-		state = StateParsing
-		if strings.HasPrefix(arg, "-") {
-			arguments = append(arguments, GCCArgument{command: arg})
-		} else {
-			arguments = append(arguments, GCCArgument{parameter: arg})
-		}
+	// a trailing option without its parameter is kept as written
+	if state == StateGettingParameter {
+		arguments = append(arguments, GCCArgument{command: argument.command, response: argument.response})
 	}
 
 	return arguments
@@ -139,6 +153,7 @@ type GCC struct {
 	ICompiler
 
 	arguments []GCCArgument
+	dir       string
 }
 
 func (c *GCC) Arguments() []IArgument {
@@ -147,36 +162,290 @@ func (c *GCC) Arguments() []IArgument {
 	})
 }
 
-func (c *GCC) Parse(args []string) error {
-	c.arguments = parseArguments(args)
+func (c *GCC) SetWorkingDirectory(dir string) {
+	c.dir = dir
+}
 
-	hasFlag := func(flag string) bool {
-		return utils.ContainsIf(c.arguments, func(arg GCCArgument) bool {
-			return arg.command == flag
-		})
+func (c *GCC) Parse(args []string) error {
+	if err := c.parse(args, newOptionTable(gccOptions())); err != nil {
+		return err
 	}
+	c.addModuleFiles()
+	return nil
+}
 
-	hasInput := utils.ContainsIf(c.arguments, func(arg GCCArgument) bool {
-		extension := filepath.Ext(arg.parameter)
-		return arg.command == "" && (extension == ".c" || extension == ".cpp")
+func (c *GCC) parse(args []string, table *optionTable) error {
+	c.arguments = parseArguments(expandResponseFiles(args, c.dir, quotingGNU), table, isGCCOption)
+
+	c.addDefaultOutputs()
+	c.addSideOutputs()
+	c.addPrecompiledHeaders()
+	return nil
+}
+
+// hasFlag tells whether any of the options is on the command line.
+func (c *GCC) hasFlag(names ...string) bool {
+	return utils.ContainsIf(c.arguments, func(arg GCCArgument) bool {
+		return !arg.implicit && arg.command != "" && utils.Contains(names, arg.command)
 	})
+}
 
-	shouldAddDefaultOutput := false
+func (c *GCC) mode(sources []string) string {
+	query := utils.ContainsIf(c.arguments, func(arg GCCArgument) bool {
+		return utils.Contains(gccQueries, arg.command) || strings.HasPrefix(arg.command, "-print-") || strings.HasPrefix(arg.command, "--print-") || strings.HasPrefix(arg.command, "--help")
+	})
 
-	// if has flag -c and not -o
-	if hasFlag("-c") && !hasFlag("-o") {
-		shouldAddDefaultOutput = true
+	switch {
+	case query || (len(sources) == 0 && !utils.ContainsIf(c.arguments, func(arg GCCArgument) bool { return arg.command == "" })):
+		return ModeQuery
+	case c.hasFlag("-M", "-MM") && !c.hasFlag("-MD", "-MMD"):
+		return ModeDependencies
+	case c.hasFlag("-E"):
+		return ModePreprocess
+	case c.hasFlag("-fsyntax-only"):
+		return ModeSyntax
+	case c.hasFlag("-S"):
+		return ModeAssemble
+	case c.hasFlag("-c", "--precompile"):
+		return ModeCompile
+	}
+	// headers are compiled into precompiled headers, they are not linked
+	if _, languages := c.sources(); len(languages) > 0 && !utils.ContainsIf(languages, func(language string) bool { return !isHeaderLanguage(language) }) {
+		return ModeCompile
 	}
+	return ModeLink
+}
 
-	// if has no -c and no -o and has input files
-	if !hasFlag("-c") && !hasFlag("-o") && hasInput {
-		shouldAddDefaultOutput = true
+func isHeaderLanguage(language string) bool {
+	return strings.HasSuffix(language, "-header")
+}
+
+// sources returns the files compiled by the command line with their languages,
+// given by -x or by their extensions. Objects and libraries are not sources.
+func (c *GCC) sources() ([]string, []string) {
+	sources, languages := make([]string, 0), make([]string, 0)
+	language := ""
+	for _, arg := range c.arguments {
+		switch {
+		case arg.command == "-x" || arg.command == "--language" || arg.command == "--language=":
+			language = arg.parameter
+			if language == "none" {
+				language = ""
+			}
+		case arg.command == "-":
+			// the standard input, which needs -x
+			sources, languages = append(sources, arg.command), append(languages, language)
+		case arg.command != "":
+		case language != "":
+			sources, languages = append(sources, arg.Parameter()), append(languages, language)
+		default:
+			if l, ok := sourceLanguage(arg.parameter); ok {
+				sources, languages = append(sources, arg.Parameter()), append(languages, l)
+			}
+		}
 	}
+	return sources, languages
+}
 
-	if shouldAddDefaultOutput {
-		c.arguments = append(c.arguments, GCCArgument{command: "-o", argType: ArgumentTypeOutput, parameter: "a.out"})
+func (c *GCC) Invocation() Invocation {
+	sources, languages := c.sources()
+	invocation := Invocation{Mode: c.mode(sources), Sources: sources}
+	if len(languages) > 0 {
+		invocation.Language = languages[0]
+	}
+
+	for _, arg := range c.arguments {
+		switch {
+		case arg.IsOutput() && utils.Contains([]string{"-o", "--output", "--output="}, arg.command) && invocation.Output == "":
+			invocation.Output = arg.Parameter()
+		case arg.IsOutput() && arg.command == "-MF":
+			invocation.Depfile = arg.Parameter()
+		}
+	}
+	invocation.SideOutputs = sideOutputs(c.arguments, invocation)
+
+	invocation.Distributable = distributable(invocation)
+	return invocation
+}
+
+// addDefaultOutputs adds the outputs gcc names on its own: the output when
+// there is no -o and the depfile of -MD and -MMD when there is no -MF.
+func (c *GCC) addDefaultOutputs() {
+	sources, _ := c.sources()
+	mode := c.mode(sources)
+	implicit := func(command string, path string) {
+		c.arguments = append(c.arguments, GCCArgument{command: command, argType: ArgumentTypeOutput, parameter: path, implicit: true})
+	}
+
+	// a header unit is compiled with -fmodule-only, the object named by -o is never written
+	_, languages := c.sources()
+	if mode == ModeCompile && len(languages) > 0 && !utils.ContainsIf(languages, func(language string) bool { return !isHeaderLanguage(language) }) &&
+		(c.hasFlag("-fmodules-ts", "-fmodule-header") || c.hasFlagPrefix("-fmodule-header=")) {
+		for i, arg := range c.arguments {
+			if arg.IsOutput() && utils.Contains([]string{"-o", "--output", "--output="}, arg.command) {
+				c.arguments[i].argType = ArgumentTypeValue
+			}
+		}
+	}
+
+	if !c.hasFlag("-o", "--output", "--output=") && !c.hasFlag("-fmodule-only") {
+		switch mode {
+		case ModeCompile:
+			extension := ".o"
+			if c.hasFlag("--precompile") {
+				// the BMI of a module interface
+				extension = ".pcm"
+			}
+			for i, source := range sources {
+				switch {
+				case !isHeaderLanguage(languages[i]):
+					implicit("-o", replaceExtension(source, extension))
+				case !c.hasFlag("-fmodules-ts", "-fmodules"):
+					// the precompiled header is written next to the header, a header unit has no output but its BMI
+					implicit("-o", source+".gch")
+				}
+			}
+		case ModeAssemble:
+			for _, source := range sources {
+				implicit("-o", replaceExtension(source, ".s"))
+			}
+		case ModeLink:
+			implicit("-o", "a.out")
+		}
+	}
+
+	if c.hasFlag("-MD", "-MMD") && !c.hasFlag("-MF") && mode != ModePreprocess {
+		// the depfile is named after the output
+		if output := c.Invocation().Output; output != "" {
+			implicit("-MF", strings.TrimSuffix(output, filepath.Ext(output))+".d")
+		}
+	}
+}
+
+// saveTempsExtensions are the extensions of the preprocessed sources of -save-temps
+var saveTempsExtensions = map[string]string{
+	"c":             ".i",
+	"c++":           ".ii",
+	"objective-c":   ".mi",
+	"objective-c++": ".mii",
+}
+
+// addSideOutputs adds the files written next to the object by --coverage,
+// -gsplit-dwarf, -fstack-usage, -ftime-trace and -save-temps, and the files of
+// -fopt-info=file. They are named after the object, -save-temps names them
+// after the source in the working directory unless it is -save-temps=obj.
+// The auxiliary outputs of GCC are named by -dumpdir and -dumpbase instead,
+// when they are given.
+func (c *GCC) addSideOutputs() {
+	dumpdir, dumpbase := "", ""
+	for i, arg := range c.arguments {
+		if strings.HasPrefix(arg.command, "-fopt-info") && strings.Contains(arg.command, "=") {
+			command, file, _ := strings.Cut(arg.command, "=")
+			c.arguments[i].command, c.arguments[i].parameter, c.arguments[i].argType = command+"=", file, ArgumentTypeOutput
+		}
+		switch arg.command {
+		case "-dumpdir":
+			dumpdir = arg.parameter
+		case "-dumpbase":
+			dumpbase = arg.parameter
+		}
+	}
+
+	sources, languages := c.sources()
+	mode := c.mode(sources)
+	if mode != ModeCompile && mode != ModeAssemble {
+		return
+	}
+	implicit := func(command string, path string) {
+		c.arguments = append(c.arguments, GCCArgument{command: command, argType: ArgumentTypeOutput, parameter: path, implicit: true})
+	}
+
+	objects := utils.Filter(c.arguments, func(arg GCCArgument) bool {
+		return arg.IsOutput() && utils.Contains([]string{"-o", "--output", "--output="}, arg.command)
+	})
+	for i, object := range objects {
+		base := strings.TrimSuffix(object.parameter, filepath.Ext(object.parameter))
+		// -dumpdir is a directory with its separator or a prefix of the names
+		aux := base
+		if dumpdir != "" || dumpbase != "" {
+			name := filepath.Base(base)
+			if dumpbase != "" {
+				name = dumpbase
+			}
+			prefix := dumpdir
+			if prefix == "" && filepath.Dir(base) != "." {
+				prefix = filepath.Dir(base) + "/"
+			}
+			aux = prefix + name
+		}
+		if c.hasFlag("--coverage", "-ftest-coverage") {
+			implicit("--coverage", aux+".gcno")
+		}
+		if c.hasFlag("-gsplit-dwarf") {
+			implicit("-gsplit-dwarf", aux+".dwo")
+		}
+		if c.hasFlag("-fstack-usage") {
+			implicit("-fstack-usage", aux+".su")
+		}
+		if c.hasFlag("-ftime-trace") {
+			implicit("-ftime-trace", base+".json")
+		}
+		for j, arg := range c.arguments {
+			// a directory of the time traces
+			if arg.command == "-ftime-trace=" && isDirectory(arg.parameter) {
+				c.arguments[j].argType = ArgumentTypeValue
+				implicit("-ftime-trace=", arg.parameter+filepath.Base(base)+".json")
+			}
+		}
+
+		if !c.hasFlag("-save-temps", "-save-temps=cwd", "-save-temps=obj") || i >= len(sources) {
+			continue
+		}
+		temps := replaceExtension(sources[i], "")
+		if c.hasFlag("-save-temps=obj") {
+			temps = aux
+		}
+		if extension, ok := saveTempsExtensions[languages[i]]; ok {
+			implicit("-save-temps", temps+extension)
+		}
+		if mode == ModeCompile {
+			implicit("-save-temps", temps+".s")
+		}
+	}
+}
+
+// addPrecompiledHeaders adds the precompiled header of -include as an input.
+// It is next to the header, as pch.h.gch, or as pch.h.pch for clang.
+func (c *GCC) addPrecompiledHeaders() {
+	sources, _ := c.sources()
+	var scanner *IncludeScanner
+	for _, arg := range c.arguments {
+		if arg.implicit || !utils.Contains([]string{"-include", "--include", "--include="}, arg.command) {
+			continue
+		}
+
+		headers := []string{arg.parameter}
+		if len(sources) > 0 {
+			if scanner == nil {
+				scanner = NewIncludeScanner(c)
+			}
+			if path, _, ok := scanner.resolve(Include{From: sources[0], Name: arg.parameter}, 0); ok {
+				headers = append(headers, path)
+			}
+		}
+		for _, header := range headers {
+			found := false
+			for _, extension := range []string{".gch", ".pch"} {
+				if _, err := os.Stat(filepath.Join(c.dir, header+extension)); err == nil {
+					c.arguments = append(c.arguments, GCCArgument{command: arg.command, argType: ArgumentTypeInput, parameter: header + extension, implicit: true})
+					found = true
+				}
+			}
+			if found {
+				break
+			}
+		}
 	}
-	return nil
 }
 
 func (c *GCC) Chroot(path string) {
@@ -186,12 +455,13 @@ func (c *GCC) Chroot(path string) {
 	}
 }
 
-func (c *GCC) GetCommand() []string {
-	command := make([]string, 0)
-
-	for _, arg := range c.arguments {
-		command = append(command, arg.Stringify())
+func (c *GCC) WriteResponseFiles() error {
+	if err := writeResponseFiles(c.arguments, quotingGNU); err != nil {
+		return err
 	}
+	return writeModuleMapper(c.arguments)
+}
 
-	return command
+func (c *GCC) GetCommand() []string {
+	return GetCommand(c)
 }
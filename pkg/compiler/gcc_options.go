@@ -0,0 +1,165 @@
+package compiler
+
+import (
+	"sort"
+	"strings"
+)
+
+// How an option receives its value. Options may accept more than one form,
+// e.g. "-I dir" and "-Idir" or "--param x=1" and "--param=x=1".
+const (
+	valueNone     = 0
+	valueJoined   = 1 << iota // -Idir
+	valueSeparate             // -I dir
+	valueEquals               // --sysroot=dir
+)
+
+// ArgumentTypeValue marks an option parameter which is neither input nor output,
+// e.g. a macro definition or a language name, so it is never remapped.
+const ArgumentTypeValue = ""
+
+type option struct {
+	name    string
+	value   int
+	argType string
+	keyed   bool // the value may be name=path, e.g. -fmodule-file=name=path, only the path is remapped
+	tree    bool // the output is a directory the compiler writes files of its own naming into
+}
+
+type optionTable struct {
+	options []option
+	byName  map[string]option
+}
+
+func newOptionTable(lists ...[]option) *optionTable {
+	table := &optionTable{byName: make(map[string]option)}
+	// later lists override earlier ones, so a dialect can refine the base table
+	for _, list := range lists {
+		for _, o := range list {
+			table.byName[o.name] = o
+		}
+	}
+	for _, o := range table.byName {
+		table.options = append(table.options, o)
+	}
+	// sort by length, so that we can match the longest first (consider prefixes "-isystem" and "-i")
+	sort.Slice(table.options, func(i int, j int) bool {
+		if len(table.options[i].name) != len(table.options[j].name) {
+			return len(table.options[i].name) > len(table.options[j].name)
+		}
+		return table.options[i].name < table.options[j].name
+	})
+	return table
+}
+
+// match finds the option described by arg. It returns the option, the command
+// part as written and the joined value, if any. When the option takes its value
+// from the next argument, separate is true.
+func (t *optionTable) match(arg string) (o option, command string, value string, separate bool, ok bool) {
+	if o, ok := t.byName[arg]; ok {
+		if o.value&valueSeparate != 0 {
+			return o, arg, "", true, true
+		}
+		if o.value == valueNone {
+			return o, arg, "", false, true
+		}
+	}
+
+	for _, o := range t.options {
+		if o.value&valueEquals != 0 && strings.HasPrefix(arg, o.name+"=") {
+			command := o.name + "="
+			return o, command, arg[len(command):], false, true
+		}
+		if o.value&valueJoined != 0 && len(arg) > len(o.name) && strings.HasPrefix(arg, o.name) {
+			return o, o.name, arg[len(o.name):], false, true
+		}
+	}
+
+	return option{}, arg, "", false, false
+}
+
+// gccOptions is the GCC driver option model. Flags without a value do not need
+// to be listed, unless they share a prefix with an option taking a joined value.
+func gccOptions() []option {
+	return []option{
+		// Output
+		{name: "-o", value: valueJoined | valueSeparate, argType: ArgumentTypeOutput},
+		{name: "--output", value: valueSeparate | valueEquals, argType: ArgumentTypeOutput},
+		{name: "-aux-info", value: valueSeparate, argType: ArgumentTypeOutput},
+		{name: "-MF", value: valueJoined | valueSeparate, argType: ArgumentTypeOutput},
+
+		// Preprocessor search paths
+		{name: "-I", value: valueJoined | valueSeparate, argType: ArgumentTypeInput},
+		{name: "--include-directory", value: valueSeparate | valueEquals, argType: ArgumentTypeInput},
+		{name: "-iquote", value: valueJoined | valueSeparate, argType: ArgumentTypeInput},
+		{name: "-isystem", value: valueJoined | valueSeparate, argType: ArgumentTypeInput},
+		{name: "-idirafter", value: valueJoined | valueSeparate, argType: ArgumentTypeInput},
+		{name: "--include-directory-after", value: valueSeparate | valueEquals, argType: ArgumentTypeInput},
+		{name: "-isysroot", value: valueJoined | valueSeparate, argType: ArgumentTypeInput},
+		{name: "--sysroot", value: valueSeparate | valueEquals, argType: ArgumentTypeInput},
+		{name: "-iprefix", value: valueJoined | valueSeparate},
+		{name: "--include-prefix", value: valueSeparate | valueEquals},
+		{name: "-iwithprefix", value: valueJoined | valueSeparate},
+		{name: "--include-with-prefix", value: valueSeparate | valueEquals},
+		{name: "--include-with-prefix-after", value: valueSeparate | valueEquals},
+		{name: "-iwithprefixbefore", value: valueJoined | valueSeparate},
+		{name: "--include-with-prefix-before", value: valueSeparate | valueEquals},
+		{name: "-imultilib", value: valueJoined | valueSeparate},
+		{name: "-imultiarch", value: valueJoined | valueSeparate},
+		{name: "-iplugindir", value: valueEquals},
+
+		// Preprocessor inputs and macros
+		{name: "-include", value: valueJoined | valueSeparate, argType: ArgumentTypeInput},
+		{name: "-include-pch", value: valueSeparate, argType: ArgumentTypeInput},
+		{name: "--include", value: valueSeparate | valueEquals, argType: ArgumentTypeInput},
+		{name: "-imacros", value: valueJoined | valueSeparate, argType: ArgumentTypeInput},
+		{name: "--imacros", value: valueSeparate | valueEquals, argType: ArgumentTypeInput},
+		{name: "-D", value: valueJoined | valueSeparate},
+		{name: "--define-macro", value: valueSeparate | valueEquals},
+		{name: "-U", value: valueJoined | valueSeparate},
+		{name: "--undefine-macro", value: valueSeparate | valueEquals},
+		{name: "-A", value: valueJoined | valueSeparate},
+		{name: "--assert", value: valueSeparate | valueEquals},
+		{name: "-MT", value: valueJoined | valueSeparate},
+		{name: "-MQ", value: valueJoined | valueSeparate},
+		{name: "-Xpreprocessor", value: valueSeparate},
+
+		// Language and driver
+		{name: "-x", value: valueJoined | valueSeparate},
+		{name: "--language", value: valueSeparate | valueEquals},
+		{name: "-B", value: valueJoined | valueSeparate},
+		{name: "--prefix", value: valueSeparate | valueEquals},
+		{name: "-specs", value: valueSeparate | valueEquals, argType: ArgumentTypeInput},
+		{name: "--specs", value: valueSeparate | valueEquals, argType: ArgumentTypeInput},
+		{name: "-wrapper", value: valueSeparate},
+		{name: "-dumpbase", value: valueSeparate},
+		{name: "-dumpbase-ext", value: valueSeparate},
+		{name: "-dumpdir", value: valueSeparate},
+		{name: "--param", value: valueSeparate | valueEquals},
+		{name: "-Xassembler", value: valueSeparate},
+		{name: "-fplugin", value: valueEquals, argType: ArgumentTypeInput},
+		{name: "-fprofile-use", value: valueEquals, argType: ArgumentTypeInput},
+		{name: "-fauto-profile", value: valueEquals, argType: ArgumentTypeInput},
+		{name: "-fmodule-mapper", value: valueEquals, argType: ArgumentTypeInput},
+
+		// Linker
+		{name: "-L", value: valueJoined | valueSeparate, argType: ArgumentTypeInput},
+		{name: "--library-directory", value: valueSeparate | valueEquals, argType: ArgumentTypeInput},
+		{name: "-l", value: valueJoined | valueSeparate},
+		{name: "-T", value: valueJoined | valueSeparate, argType: ArgumentTypeInput},
+		{name: "-Tbss", value: valueSeparate},
+		{name: "-Tdata", value: valueSeparate},
+		{name: "-Ttext", value: valueSeparate},
+		{name: "-u", value: valueJoined | valueSeparate},
+		{name: "-e", value: valueJoined | valueSeparate},
+		{name: "--entry", value: valueSeparate | valueEquals},
+		{name: "-z", value: valueJoined | valueSeparate},
+		{name: "-Xlinker", value: valueSeparate},
+		{name: "--for-linker", value: valueSeparate | valueEquals},
+		{name: "--force-link", value: valueSeparate | valueEquals},
+
+		// Flags sharing a prefix with an option above
+		{name: "-undef"},
+		{name: "-I-"},
+	}
+}
@@ -0,0 +1,126 @@
+package compiler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var gccCorpus = []corpusCase{
+	{"include joined", []string{"-c", "-Iinc", "a.c", "-o", "a.o"}, []string{"inc", "a.c"}, []string{"a.o"}},
+	{"include separate", []string{"-c", "-I", "inc", "a.c", "-o", "a.o"}, []string{"inc", "a.c"}, []string{"a.o"}},
+	{"include long", []string{"-c", "--include-directory=inc", "--include-directory", "inc2", "a.c"}, []string{"inc", "inc2", "a.c"}, []string{"a.o"}},
+	{"isystem", []string{"-c", "-isystem", "sys", "-isystemsys2", "a.c", "-o", "a.o"}, []string{"sys", "sys2", "a.c"}, []string{"a.o"}},
+	{"iquote", []string{"-c", "-iquote", "q", "-iquoteq2", "a.c", "-o", "a.o"}, []string{"q", "q2", "a.c"}, []string{"a.o"}},
+	{"idirafter", []string{"-c", "-idirafter", "after", "-idirafterafter2", "a.c", "-o", "a.o"}, []string{"after", "after2", "a.c"}, []string{"a.o"}},
+	{"isysroot", []string{"-c", "-isysroot", "root", "a.c", "-o", "a.o"}, []string{"root", "a.c"}, []string{"a.o"}},
+	{"sysroot equals", []string{"-c", "--sysroot=root", "a.c", "-o", "a.o"}, []string{"root", "a.c"}, []string{"a.o"}},
+	{"sysroot separate", []string{"-c", "--sysroot", "root", "a.c", "-o", "a.o"}, []string{"root", "a.c"}, []string{"a.o"}},
+	{"define separate", []string{"-c", "-D", "FOO", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"define joined", []string{"-c", "-DFOO=1", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"define long", []string{"-c", "--define-macro", "FOO", "--define-macro=BAR", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"undefine", []string{"-c", "-U", "FOO", "-UBAR", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"undef flag", []string{"-c", "-undef", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"assert", []string{"-c", "-A", "machine(x86)", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"forced include separate", []string{"-c", "-include", "foo.h", "a.c", "-o", "a.o"}, []string{"foo.h", "a.c"}, []string{"a.o"}},
+	{"forced include joined", []string{"-c", "-includefoo.h", "a.c", "-o", "a.o"}, []string{"foo.h", "a.c"}, []string{"a.o"}},
+	{"forced include long", []string{"-c", "--include=foo.h", "a.c", "-o", "a.o"}, []string{"foo.h", "a.c"}, []string{"a.o"}},
+	{"forced include pch", []string{"-c", "-include-pch", "foo.pch", "a.c", "-o", "a.o"}, []string{"foo.pch", "a.c"}, []string{"a.o"}},
+	{"imacros", []string{"-c", "-imacros", "macros.h", "a.c", "-o", "a.o"}, []string{"macros.h", "a.c"}, []string{"a.o"}},
+	{"depfile", []string{"-c", "-MD", "-MF", "dep.d", "-MT", "target", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"dep.d", "a.o"}},
+	{"depfile joined", []string{"-c", "-MMD", "-MFdep.d", "-MTtarget", "-MQ", "quoted", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"dep.d", "a.o"}},
+	{"depfile flags", []string{"-c", "-MD", "-MP", "-MG", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o", "a.d"}},
+	{"depfile default", []string{"-c", "-MMD", "src/a.c", "-o", "out/a.c.o"}, []string{"src/a.c"}, []string{"out/a.c.o", "out/a.c.d"}},
+	{"depfile default object", []string{"-c", "-MD", "src/a.c"}, []string{"src/a.c"}, []string{"a.o", "a.d"}},
+	{"language separate", []string{"-c", "-x", "c++", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"language joined", []string{"-c", "-xc++", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"language long", []string{"-c", "--language=c++", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"output joined", []string{"-c", "a.c", "-oa.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"output long", []string{"-c", "a.c", "--output=a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"output default compile", []string{"-c", "src/a.c"}, []string{"src/a.c"}, []string{"a.o"}},
+	{"output default assembly", []string{"-S", "a.c"}, []string{"a.c"}, []string{"a.s"}},
+	{"output default multiple", []string{"-c", "a.c", "b.cpp"}, []string{"a.c", "b.cpp"}, []string{"a.o", "b.o"}},
+	{"output default objects", []string{"a.o", "b.o"}, []string{"a.o", "b.o"}, []string{"a.out"}},
+	{"output preprocess", []string{"-E", "a.c"}, []string{"a.c"}, []string{}},
+	{"output default link", []string{"a.cpp"}, []string{"a.cpp"}, []string{"a.out"}},
+	{"xlinker", []string{"a.o", "-Xlinker", "--gc-sections", "-o", "app"}, []string{"a.o"}, []string{"app"}},
+	{"xlinker rpath", []string{"a.o", "-Xlinker", "-rpath", "-Xlinker", "lib", "-o", "app"}, []string{"a.o"}, []string{"app"}},
+	{"xassembler", []string{"-c", "-Xassembler", "--noexecstack", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"xpreprocessor", []string{"-c", "-Xpreprocessor", "-dD", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"wl", []string{"a.o", "-Wl,-rpath,lib", "-o", "app"}, []string{"a.o"}, []string{"app"}},
+	{"param separate", []string{"-c", "--param", "max-inline-insns-single=10", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"param equals", []string{"-c", "--param=max-inline-insns-single=10", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"iprefix", []string{"-c", "-iprefix", "pre/", "-iwithprefix", "inc", "-iwithprefixbefore", "inc2", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"imultilib", []string{"-c", "-imultilib", "32", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"library dirs", []string{"a.o", "-L", "lib", "-Llib2", "-lfoo", "-l", "bar", "-o", "app"}, []string{"a.o", "lib", "lib2"}, []string{"app"}},
+	{"linker script", []string{"a.o", "-T", "link.ld", "-o", "app"}, []string{"a.o", "link.ld"}, []string{"app"}},
+	{"linker sections", []string{"a.o", "-Ttext", "0x1000", "-o", "app"}, []string{"a.o"}, []string{"app"}},
+	{"undefined symbol", []string{"a.o", "-u", "main", "-o", "app"}, []string{"a.o"}, []string{"app"}},
+	{"entry", []string{"a.o", "-e", "start", "-o", "app"}, []string{"a.o"}, []string{"app"}},
+	{"z keyword", []string{"a.o", "-z", "now", "-o", "app"}, []string{"a.o"}, []string{"app"}},
+	{"specs", []string{"-c", "-specs=nano.specs", "a.c", "-o", "a.o"}, []string{"nano.specs", "a.c"}, []string{"a.o"}},
+	{"prefix", []string{"-c", "-B", "bin/", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"wrapper", []string{"-c", "-wrapper", "gdb,--args", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"dumpbase", []string{"-c", "-dumpbase", "a", "-dumpdir", "out/", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"dumpdir prefix", []string{"-c", "-dumpdir", "out/a-", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"dumpdir object directory", []string{"-c", "-dumpdir", "out/", "a.c", "-o", "out/a.o"}, []string{"a.c"}, []string{"out/a.o"}},
+	{"dumpdir aux outputs", []string{"-c", "-fstack-usage", "--coverage", "-dumpdir", "aux/", "a.c", "-o", "out/a.o"}, []string{"a.c"}, []string{"out/a.o", "aux/a.gcno", "aux/a.su"}},
+	{"dumpdir aux prefix", []string{"-c", "-fstack-usage", "-dumpdir", "aux/p-", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o", "aux/p-a.su"}},
+	{"dumpbase aux outputs", []string{"-c", "-gsplit-dwarf", "-dumpbase", "b", "a.c", "-o", "out/a.o"}, []string{"a.c"}, []string{"out/a.o", "out/b.dwo"}},
+	{"aux info", []string{"-c", "-aux-info", "protos.txt", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"protos.txt", "a.o"}},
+	{"plugin", []string{"-c", "-fplugin=plugin.so", "a.c", "-o", "a.o"}, []string{"plugin.so", "a.c"}, []string{"a.o"}},
+	{"profile use", []string{"-c", "-fprofile-use=a.gcda", "a.c", "-o", "a.o"}, []string{"a.gcda", "a.c"}, []string{"a.o"}},
+	{"profile use flag", []string{"-c", "-fprofile-use", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"a.o"}},
+	{"std and warnings", []string{"-c", "-std=c++20", "-Wall", "-Wextra", "-O2", "-g", "a.cpp", "-o", "a.o"}, []string{"a.cpp"}, []string{"a.o"}},
+	{"stdin", []string{"-c", "-x", "c", "-", "-o", "a.o"}, []string{}, []string{"a.o"}},
+	{"trailing option", []string{"-c", "a.c", "-o", "a.o", "-I"}, []string{"a.c"}, []string{"a.o"}},
+	{"coverage", []string{"-c", "--coverage", "a.c", "-o", "out/a.o"}, []string{"a.c"}, []string{"out/a.o", "out/a.gcno"}},
+	{"split dwarf", []string{"-c", "-g", "-gsplit-dwarf", "-fstack-usage", "a.c"}, []string{"a.c"}, []string{"a.o", "a.dwo", "a.su"}},
+	{"save temps", []string{"-c", "-save-temps", "src/a.cpp", "-o", "out/a.o"}, []string{"src/a.cpp"}, []string{"out/a.o", "a.ii", "a.s"}},
+	{"save temps obj", []string{"-S", "-save-temps=obj", "a.c", "-o", "out/a.s"}, []string{"a.c"}, []string{"out/a.s", "out/a.i"}},
+	{"opt info", []string{"-c", "-O2", "-fopt-info-vec-missed=vec.txt", "-fopt-info", "a.c", "-o", "a.o"}, []string{"a.c"}, []string{"vec.txt", "a.o"}},
+	{"pch create", []string{"-x", "c++-header", "inc/pch.h"}, []string{"inc/pch.h"}, []string{"inc/pch.h.gch"}},
+	{"pch create named", []string{"-c", "-x", "c++-header", "pch.h", "-o", "out/pch.h.gch"}, []string{"pch.h"}, []string{"out/pch.h.gch"}},
+}
+
+func TestGCCCorpus(t *testing.T) {
+	runCorpus(t, GCCCompiler, gccCorpus)
+}
+
+func TestGCCPrecompiledHeader(t *testing.T) {
+	writeTree(t, map[string]string{
+		"pch.h":           "",
+		"pch.h.gch":       "",
+		"inc/other.h":     "",
+		"inc/other.h.gch": "",
+		"plain.h":         "",
+	})
+
+	cases := []struct {
+		args   []string
+		inputs []string
+	}{
+		{[]string{"-c", "-include", "pch.h", "a.cpp", "-o", "a.o"}, []string{"pch.h", "a.cpp", "pch.h.gch"}},
+		{[]string{"-c", "-I", "inc", "-include", "other.h", "a.cpp", "-o", "a.o"}, []string{"inc", "other.h", "a.cpp", "inc/other.h.gch"}},
+		{[]string{"-c", "-include", "plain.h", "a.cpp", "-o", "a.o"}, []string{"plain.h", "a.cpp"}},
+	}
+	for _, tc := range cases {
+		gcc := NewCompiler(GCCCompiler)
+		gcc.Parse(tc.args)
+		if inputs := GetInputs(gcc); !reflect.DeepEqual(inputs, tc.inputs) {
+			t.Errorf("%v: expected inputs ['%s'], got ['%s']", tc.args, strings.Join(tc.inputs, "', '"), strings.Join(inputs, "', '"))
+		}
+	}
+}
+
+func TestGCCChrootKeepsValues(t *testing.T) {
+	gcc := NewCompiler(GCCCompiler)
+	gcc.Parse([]string{"-c", "-D", "FOO=/usr", "-x", "c++", "-I", "inc", "-MF", "dep.d", "-MT", "target", "a.c", "-o", "a.o"})
+	gcc.Chroot("/tmp/root")
+
+	expected := []string{"-c", "-D", "FOO=/usr", "-x", "c++", "-I", "/tmp/root/inc", "-MF", "/tmp/root/dep.d", "-MT", "target", "/tmp/root/a.c", "-o", "/tmp/root/a.o"}
+	command := GetCommand(gcc)
+	if !reflect.DeepEqual(command, expected) {
+		t.Errorf("Expected command ['%s'], got ['%s']", strings.Join(expected, "', '"), strings.Join(command, "', '"))
+	}
+}
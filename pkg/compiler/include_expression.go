@@ -0,0 +1,421 @@
+package compiler
+
+import (
+	"strconv"
+	"strings"
+)
+
+// results of a preprocessor condition
+const (
+	valueFalse = iota
+	valueTrue
+	valueUnknown
+)
+
+func not(value int) int {
+	switch value {
+	case valueTrue:
+		return valueFalse
+	case valueFalse:
+		return valueTrue
+	}
+	return valueUnknown
+}
+
+// number is an integer which may be unknown, e.g. a macro predefined by the compiler.
+type number struct {
+	value int64
+	known bool
+}
+
+var unknown = number{}
+
+func known(value int64) number {
+	return number{value: value, known: true}
+}
+
+func boolean(b bool) number {
+	if b {
+		return known(1)
+	}
+	return known(0)
+}
+
+// evaluator is a small #if expression evaluator, which tracks unknown values
+// instead of failing, so that undecidable branches are scanned too.
+type evaluator struct {
+	tu    *translationUnit
+	from  string
+	index int
+
+	tokens []string
+	pos    int
+	depth  int
+}
+
+func (e *evaluator) evaluate(expression string) int {
+	e.tokens = e.tokenize(expression)
+	e.pos = 0
+
+	result := e.ternary()
+	if e.pos != len(e.tokens) || !result.known {
+		return valueUnknown
+	}
+	if result.value != 0 {
+		return valueTrue
+	}
+	return valueFalse
+}
+
+// tokenize splits the expression and resolves defined() and __has_include(),
+// which must not be macro expanded.
+func (e *evaluator) tokenize(expression string) []string {
+	tokens := make([]string, 0)
+	operators := []string{"<<", ">>", "<=", ">=", "==", "!=", "&&", "||"}
+
+	for i := 0; i < len(expression); {
+		c := expression[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case isIdentifierChar(c):
+			start := i
+			for i < len(expression) && isIdentifierChar(expression[i]) {
+				i++
+			}
+			word := expression[start:i]
+
+			switch word {
+			case "defined":
+				name, end := definedOperand(expression, i)
+				i = end
+				tokens = append(tokens, e.literal(e.tu.defined(name)))
+			case "__has_include", "__has_include_next":
+				argument, end := parenthesized(expression, i)
+				i = end
+				tokens = append(tokens, e.literal(e.hasInclude(argument, word == "__has_include_next")))
+			default:
+				tokens = append(tokens, word)
+			}
+		case c == '\'':
+			end := strings.IndexByte(expression[i+1:], '\'')
+			if end < 0 {
+				return append(tokens, "?unknown")
+			}
+			tokens = append(tokens, expression[i:i+end+2])
+			i += end + 2
+		default:
+			operator := string(c)
+			for _, op := range operators {
+				if strings.HasPrefix(expression[i:], op) {
+					operator = op
+					break
+				}
+			}
+			tokens = append(tokens, operator)
+			i += len(operator)
+		}
+	}
+	return tokens
+}
+
+func (e *evaluator) literal(value int) string {
+	switch value {
+	case valueTrue:
+		return "1"
+	case valueFalse:
+		return "0"
+	}
+	return "?unknown"
+}
+
+func (e *evaluator) hasInclude(argument string, next bool) int {
+	include, ok := e.tu.parseInclude(argument, e.from)
+	if !ok {
+		return valueUnknown
+	}
+	start := 0
+	if next {
+		start = e.index + 1
+	}
+	if _, _, ok := e.tu.scanner.resolve(include, start); ok {
+		return valueTrue
+	}
+	if include.Angled {
+		// it may still be provided by the toolchain
+		return valueUnknown
+	}
+	return valueFalse
+}
+
+func definedOperand(expression string, i int) (string, int) {
+	rest := strings.TrimLeft(expression[i:], " \t")
+	i = len(expression) - len(rest)
+	if strings.HasPrefix(rest, "(") {
+		argument, end := parenthesized(expression, i)
+		return strings.TrimSpace(argument), end
+	}
+	name := identifier(rest)
+	return name, i + len(name)
+}
+
+// parenthesized returns the content of the parenthesis starting at or after i.
+func parenthesized(expression string, i int) (string, int) {
+	for i < len(expression) && expression[i] != '(' {
+		i++
+	}
+	depth := 0
+	for j := i; j < len(expression); j++ {
+		switch expression[j] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return expression[i+1 : j], j + 1
+			}
+		}
+	}
+	return "", len(expression)
+}
+
+func (e *evaluator) peek() string {
+	if e.pos < len(e.tokens) {
+		return e.tokens[e.pos]
+	}
+	return ""
+}
+
+func (e *evaluator) next() string {
+	token := e.peek()
+	e.pos++
+	return token
+}
+
+func (e *evaluator) ternary() number {
+	condition := e.binary(0)
+	if e.peek() != "?" {
+		return condition
+	}
+	e.next()
+	whenTrue := e.ternary()
+	if e.next() != ":" {
+		return unknown
+	}
+	whenFalse := e.ternary()
+
+	switch {
+	case !condition.known && whenTrue == whenFalse:
+		return whenTrue
+	case !condition.known:
+		return unknown
+	case condition.value != 0:
+		return whenTrue
+	}
+	return whenFalse
+}
+
+var precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"|":  3,
+	"^":  4,
+	"&":  5,
+	"==": 6, "!=": 6,
+	"<": 7, ">": 7, "<=": 7, ">=": 7,
+	"<<": 8, ">>": 8,
+	"+": 9, "-": 9,
+	"*": 10, "/": 10, "%": 10,
+}
+
+func (e *evaluator) binary(minimum int) number {
+	left := e.unary()
+	for {
+		operator := e.peek()
+		p, ok := precedence[operator]
+		if !ok || p <= minimum {
+			return left
+		}
+		e.next()
+		right := e.binary(p)
+		left = apply(operator, left, right)
+	}
+}
+
+func apply(operator string, left number, right number) number {
+	// short circuits decide even with one unknown side
+	switch operator {
+	case "&&":
+		if (left.known && left.value == 0) || (right.known && right.value == 0) {
+			return known(0)
+		}
+	case "||":
+		if (left.known && left.value != 0) || (right.known && right.value != 0) {
+			return known(1)
+		}
+	}
+	if !left.known || !right.known {
+		return unknown
+	}
+
+	l, r := left.value, right.value
+	switch operator {
+	case "&&":
+		return boolean(l != 0 && r != 0)
+	case "||":
+		return boolean(l != 0 || r != 0)
+	case "|":
+		return known(l | r)
+	case "^":
+		return known(l ^ r)
+	case "&":
+		return known(l & r)
+	case "==":
+		return boolean(l == r)
+	case "!=":
+		return boolean(l != r)
+	case "<":
+		return boolean(l < r)
+	case ">":
+		return boolean(l > r)
+	case "<=":
+		return boolean(l <= r)
+	case ">=":
+		return boolean(l >= r)
+	case "<<":
+		return known(l << uint64(r&63))
+	case ">>":
+		return known(l >> uint64(r&63))
+	case "+":
+		return known(l + r)
+	case "-":
+		return known(l - r)
+	case "*":
+		return known(l * r)
+	case "/", "%":
+		if r == 0 {
+			return unknown
+		}
+		if operator == "/" {
+			return known(l / r)
+		}
+		return known(l % r)
+	}
+	return unknown
+}
+
+func (e *evaluator) unary() number {
+	switch e.peek() {
+	case "!":
+		e.next()
+		value := e.unary()
+		if !value.known {
+			return unknown
+		}
+		return boolean(value.value == 0)
+	case "-":
+		e.next()
+		value := e.unary()
+		if !value.known {
+			return unknown
+		}
+		return known(-value.value)
+	case "+":
+		e.next()
+		return e.unary()
+	case "~":
+		e.next()
+		value := e.unary()
+		if !value.known {
+			return unknown
+		}
+		return known(^value.value)
+	case "(":
+		e.next()
+		value := e.ternary()
+		if e.next() != ")" {
+			return unknown
+		}
+		return value
+	}
+	return e.primary()
+}
+
+func (e *evaluator) primary() number {
+	token := e.next()
+	switch {
+	case token == "":
+		return unknown
+	case token == "?unknown":
+		return unknown
+	case token[0] == '\'':
+		if len(token) == 3 {
+			return known(int64(token[1]))
+		}
+		return unknown
+	case token[0] >= '0' && token[0] <= '9':
+		return parseNumber(token)
+	case token == "true":
+		return known(1)
+	case token == "false":
+		return known(0)
+	case isIdentifierChar(token[0]):
+		if e.peek() == "(" {
+			// function-like macros and feature checks (__has_feature, __GNUC_PREREQ) are not expanded
+			e.skipParenthesis()
+			return unknown
+		}
+		return e.expand(token)
+	}
+	return unknown
+}
+
+func (e *evaluator) skipParenthesis() {
+	depth := 0
+	for e.pos < len(e.tokens) {
+		switch e.next() {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return
+			}
+		}
+	}
+}
+
+func (e *evaluator) expand(name string) number {
+	m, ok := e.tu.lookup(name)
+	if !ok {
+		if isReservedIdentifier(name) {
+			return unknown
+		}
+		return known(0)
+	}
+	if !m.known || m.value == "" || e.depth > 16 {
+		return unknown
+	}
+
+	nested := &evaluator{tu: e.tu, from: e.from, index: e.index, depth: e.depth + 1}
+	nested.tokens = nested.tokenize(m.value)
+	value := nested.ternary()
+	if nested.pos != len(nested.tokens) {
+		return unknown
+	}
+	return value
+}
+
+func parseNumber(token string) number {
+	token = strings.ToLower(strings.ReplaceAll(token, "'", ""))
+	token = strings.TrimRight(token, "ul")
+	value, err := strconv.ParseInt(token, 0, 64)
+	if err != nil {
+		unsigned, err := strconv.ParseUint(token, 0, 64)
+		if err != nil {
+			return unknown
+		}
+		return known(int64(unsigned))
+	}
+	return known(value)
+}
@@ -0,0 +1,733 @@
+package compiler
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Zeeno-atl/all-build/internal/utils"
+)
+
+// Include is a single #include directive.
+type Include struct {
+	From   string // the including file
+	Name   string // the header as written, e.g. foo/bar.h
+	Angled bool
+	// Computed includes name a macro which could not be expanded, Name is the macro.
+	Computed bool
+}
+
+type ScanResult struct {
+	// Files are the sources, forced includes and every header they reach.
+	Files []string
+	// Unresolved are the includes which were certainly reached but could not be found
+	// in the search path, including computed includes with an unknown macro.
+	// Angled includes which are not found are assumed to come from the toolchain
+	// and are not reported.
+	Unresolved []Include
+	// Incomplete is set when a source needed more work than maxScanWork, the
+	// files found are not all its dependencies.
+	Incomplete bool
+}
+
+// IncludeScanner follows #include and #include_next directives through the same
+// search order as the preprocessor, so only the headers a translation unit uses
+// are reported. Conditions which cannot be decided (e.g. they depend on macros
+// predefined by the compiler) are treated as if every branch was taken.
+type IncludeScanner struct {
+	Sources []string
+	Forced  []string // -include and -imacros, processed before every source
+
+	Quote  []string // -iquote
+	Angled []string // -I
+	System []string // -isystem
+	After  []string // -idirafter
+
+	Defines   map[string]string
+	Undefines []string
+
+	exists map[string]bool
+}
+
+var (
+	scannerQuote  = []string{"-iquote"}
+	scannerAngled = []string{"-I", "--include-directory", "--include-directory=", "/I"}
+	scannerSystem = []string{"-isystem", "/external:I", "-external:I", "/imsvc", "-imsvc"}
+	scannerAfter  = []string{"-idirafter", "-isystem-after", "--include-directory-after", "--include-directory-after="}
+	scannerForced = []string{"-include", "--include", "--include=", "-imacros", "--imacros", "--imacros=", "/FI", "-FI"}
+	scannerDefine = []string{"-D", "--define-macro", "--define-macro=", "/D"}
+	scannerUndef  = []string{"-U", "--undefine-macro", "--undefine-macro=", "/U"}
+)
+
+func NewIncludeScanner(c ICompiler) *IncludeScanner {
+	s := &IncludeScanner{Defines: make(map[string]string), exists: make(map[string]bool)}
+	s.Sources = utils.Filter(c.Invocation().Sources, func(source string) bool {
+		return source != "-"
+	})
+
+	for _, arg := range c.Arguments() {
+		command := arg.Command()
+		switch {
+		case utils.Contains(scannerQuote, command):
+			s.Quote = append(s.Quote, arg.Parameter())
+		case utils.Contains(scannerAngled, command):
+			s.Angled = append(s.Angled, arg.Parameter())
+		case utils.Contains(scannerSystem, command):
+			s.System = append(s.System, arg.Parameter())
+		case utils.Contains(scannerAfter, command):
+			s.After = append(s.After, arg.Parameter())
+		case utils.Contains(scannerForced, command):
+			s.Forced = append(s.Forced, arg.Parameter())
+		case utils.Contains(scannerDefine, command):
+			name, value, ok := strings.Cut(arg.Parameter(), "=")
+			if !ok {
+				value = "1"
+			}
+			s.Defines[name] = value
+		case utils.Contains(scannerUndef, command):
+			s.Undefines = append(s.Undefines, arg.Parameter())
+		}
+	}
+
+	return s
+}
+
+// SearchPath returns all directories searched for headers, in search order.
+func (s *IncludeScanner) SearchPath() []string {
+	dirs := make([]string, 0)
+	dirs = append(dirs, s.Quote...)
+	dirs = append(dirs, s.Angled...)
+	dirs = append(dirs, s.System...)
+	dirs = append(dirs, s.After...)
+	return dirs
+}
+
+func (s *IncludeScanner) Scan() ScanResult {
+	result := ScanResult{}
+	files := make([]string, 0)
+	unresolved := make(map[Include]bool)
+
+	for _, source := range s.Sources {
+		if !s.isFile(source) {
+			continue
+		}
+
+		tu := &translationUnit{scanner: s, visits: make(map[visit][]visitState), once: make(map[string]bool), guards: make(map[string]string), lines: make(map[string][]string), macros: make(map[string]macro)}
+		for name, value := range s.Defines {
+			tu.macros[name] = macro{value: value, known: true}
+		}
+		for _, name := range s.Undefines {
+			delete(tu.macros, name)
+		}
+
+		for _, forced := range s.Forced {
+			// the working directory is searched first, before the include chain
+			if s.isFile(forced) {
+				tu.scan(forced, -1)
+			} else if path, index, ok := s.resolve(Include{From: source, Name: forced}, 0); ok {
+				tu.scan(path, index)
+			}
+		}
+		tu.scan(source, -1)
+
+		files = append(files, tu.files...)
+		result.Incomplete = result.Incomplete || tu.exhausted
+		for _, include := range tu.unresolved {
+			if !unresolved[include] {
+				unresolved[include] = true
+				result.Unresolved = append(result.Unresolved, include)
+			}
+		}
+	}
+
+	result.Files = utils.Unique(files)
+	return result
+}
+
+func (s *IncludeScanner) isFile(path string) bool {
+	if exists, ok := s.exists[path]; ok {
+		return exists
+	}
+	info, err := os.Stat(path)
+	exists := err == nil && info.Mode().IsRegular()
+	s.exists[path] = exists
+	return exists
+}
+
+// resolve searches the include chain starting at index. Quoted includes are
+// searched in the directory of the including file first, which has index -1.
+// The returned index is where the header was found, for #include_next.
+func (s *IncludeScanner) resolve(include Include, start int) (string, int, bool) {
+	if filepath.IsAbs(include.Name) {
+		return include.Name, -1, s.isFile(include.Name)
+	}
+
+	if !include.Angled && start <= 0 {
+		path := filepath.Join(filepath.Dir(include.From), include.Name)
+		if s.isFile(path) {
+			return path, -1, true
+		}
+	}
+
+	dirs := s.SearchPath()
+	if include.Angled && start < len(s.Quote) {
+		start = len(s.Quote)
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	for i := start; i < len(dirs); i++ {
+		path := filepath.Join(dirs[i], include.Name)
+		if s.isFile(path) {
+			return path, i, true
+		}
+	}
+	return "", -1, false
+}
+
+type macro struct {
+	value string
+	known bool // false when the macro was (un)defined in a branch which may not be taken
+}
+
+type translationUnit struct {
+	scanner    *IncludeScanner
+	visits     map[visit][]visitState
+	once       map[string]bool   // the headers with #pragma once
+	guards     map[string]string // the include guards of the headers, "" without one
+	lines      map[string][]string
+	macros     map[string]macro
+	frames     []*frame // the headers being scanned
+	work       int
+	exhausted  bool
+	files      []string
+	unresolved []Include
+}
+
+type visit struct {
+	path  string
+	index int
+}
+
+// visitState is the state of the macros a header and the headers it reached
+// tested when it was scanned. A header included again under the same state
+// includes the same headers and is not scanned again.
+type visitState struct {
+	names     []string
+	signature string
+}
+
+// frame collects the macros tested by a header being scanned, with their
+// values before it was entered.
+type frame struct {
+	entry  map[string]string
+	tested map[string]bool
+}
+
+// maxIncludeDepth is the nesting limit of GCC, it stops headers including
+// each other without guards.
+const maxIncludeDepth = 200
+
+// maxScanWork limits the directives scanned for a source, a source including
+// the common headers of libstdc++ scans about 150000
+const maxScanWork = 4 << 20
+
+// branch states of a conditional block
+const (
+	branchInactive = iota
+	branchMaybe
+	branchActive
+)
+
+type conditional struct {
+	parent int
+	taken  int // whether an earlier branch was taken: branchInactive, branchMaybe or branchActive
+	state  int
+}
+
+func (tu *translationUnit) scan(path string, index int) {
+	if tu.once[path] || len(tu.frames) >= maxIncludeDepth || tu.exhausted {
+		return
+	}
+	key := visit{path: path, index: index}
+	scanned := len(tu.visits[key]) > 0
+	if !utils.ContainsIf(tu.files, func(file string) bool { return file == path }) {
+		tu.files = append(tu.files, path)
+	}
+
+	lines, ok := tu.lines[path]
+	if !ok {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return
+		}
+		lines = directives(content)
+		tu.lines[path] = lines
+		tu.guards[path] = includeGuard(lines)
+	}
+
+	// a header whose guard is defined, also in a branch which may not be taken,
+	// is skipped like the preprocessors do
+	if guard := tu.guards[path]; guard != "" && scanned {
+		if _, ok := tu.lookup(guard); ok {
+			return
+		}
+	}
+	for _, state := range tu.visits[key] {
+		if tu.signature(state.names) == state.signature {
+			// the includes of the header depend on the same macros in the includer
+			for _, name := range state.names {
+				tu.lookup(name)
+			}
+			return
+		}
+	}
+
+	current := &frame{entry: make(map[string]string), tested: make(map[string]bool)}
+	tu.frames = append(tu.frames, current)
+	defer func() {
+		tu.frames = tu.frames[:len(tu.frames)-1]
+		if len(tu.frames) > 0 {
+			// a macro not touched by the includer before had the same value when it was entered
+			parent := tu.frames[len(tu.frames)-1]
+			for name, value := range current.entry {
+				if _, ok := parent.entry[name]; !ok {
+					parent.entry[name] = value
+				}
+			}
+			for name := range current.tested {
+				parent.tested[name] = true
+			}
+		}
+		state := visitState{names: make([]string, 0, len(current.tested))}
+		for name := range current.tested {
+			state.names = append(state.names, name)
+		}
+		sort.Strings(state.names)
+		var signature strings.Builder
+		for _, name := range state.names {
+			signature.WriteString(name + current.entry[name] + "\n")
+		}
+		state.signature = signature.String()
+		tu.visits[key] = append(tu.visits[key], state)
+	}()
+
+	tu.work += len(lines)
+	if tu.work > maxScanWork {
+		tu.exhausted = true
+		return
+	}
+
+	stack := make([]conditional, 0)
+	state := func() int {
+		if len(stack) == 0 {
+			return branchActive
+		}
+		return stack[len(stack)-1].state
+	}
+
+	for _, line := range lines {
+		name, rest := splitDirective(line)
+
+		switch name {
+		case "if", "ifdef", "ifndef":
+			parent := state()
+			value := valueFalse
+			if parent != branchInactive {
+				value = tu.condition(name, rest, path, index)
+			}
+			frame := conditional{parent: parent}
+			frame.enter(value)
+			stack = append(stack, frame)
+			continue
+		case "elif", "elifdef", "elifndef":
+			if len(stack) == 0 {
+				continue
+			}
+			frame := &stack[len(stack)-1]
+			value := valueFalse
+			if frame.parent != branchInactive && frame.taken != branchActive {
+				value = tu.condition(strings.TrimPrefix(name, "el"), rest, path, index)
+			}
+			frame.enter(value)
+			continue
+		case "else":
+			if len(stack) > 0 {
+				stack[len(stack)-1].enter(valueTrue)
+			}
+			continue
+		case "endif":
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+
+		current := state()
+		if current == branchInactive {
+			continue
+		}
+
+		switch name {
+		case "pragma":
+			if strings.TrimSpace(rest) == "once" && current == branchActive {
+				tu.once[path] = true
+			}
+		case "define":
+			if macroName, value, ok := parseDefine(rest); ok {
+				tu.touch(macroName)
+				tu.macros[macroName] = macro{value: value, known: current == branchActive}
+			}
+		case "undef":
+			macroName := strings.TrimSpace(rest)
+			tu.touch(macroName)
+			if current == branchActive {
+				delete(tu.macros, macroName)
+			} else {
+				tu.macros[macroName] = macro{known: false}
+			}
+		case "include", "import", "include_next":
+			start := 0
+			if name == "include_next" {
+				start = index + 1
+			}
+			include, ok := tu.parseInclude(rest, path)
+			if !ok {
+				if current == branchActive {
+					tu.unresolved = append(tu.unresolved, Include{From: path, Name: strings.TrimSpace(rest), Computed: true})
+				}
+				continue
+			}
+			resolved, found, ok := tu.scanner.resolve(include, start)
+			if !ok {
+				if current == branchActive && !include.Angled {
+					tu.unresolved = append(tu.unresolved, include)
+				}
+				continue
+			}
+			tu.scan(resolved, found)
+		}
+	}
+}
+
+// touch records the value of a macro before the header being scanned changes
+// it, the includers get it when the header is done.
+func (tu *translationUnit) touch(name string) {
+	if len(tu.frames) == 0 {
+		return
+	}
+	f := tu.frames[len(tu.frames)-1]
+	if _, ok := f.entry[name]; !ok {
+		f.entry[name] = tu.state(name)
+	}
+}
+
+// lookup returns a macro tested by the header being scanned.
+func (tu *translationUnit) lookup(name string) (macro, bool) {
+	tu.touch(name)
+	if len(tu.frames) > 0 {
+		tu.frames[len(tu.frames)-1].tested[name] = true
+	}
+	m, ok := tu.macros[name]
+	return m, ok
+}
+
+func (tu *translationUnit) state(name string) string {
+	m, ok := tu.macros[name]
+	switch {
+	case !ok:
+		return " undefined"
+	case !m.known:
+		return " unknown"
+	}
+	return "=" + m.value
+}
+
+func (tu *translationUnit) signature(names []string) string {
+	var signature strings.Builder
+	for _, name := range names {
+		signature.WriteString(name + tu.state(name) + "\n")
+	}
+	return signature.String()
+}
+
+// includeGuard returns the macro guarding a whole header, which starts with
+// #ifndef GUARD and #define GUARD and ends with the matching #endif.
+func includeGuard(lines []string) string {
+	if len(lines) < 3 {
+		return ""
+	}
+	name, rest := splitDirective(lines[0])
+	guard := ""
+	switch name {
+	case "ifndef":
+		guard = strings.TrimSpace(rest)
+	case "if":
+		rest = strings.TrimSpace(rest)
+		if !strings.HasPrefix(rest, "!") {
+			return ""
+		}
+		rest = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rest[1:]), "defined"))
+		guard = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(rest, "("), ")"))
+	}
+	if guard == "" || identifier(guard) != guard {
+		return ""
+	}
+	if name, rest := splitDirective(lines[1]); name != "define" || identifier(strings.TrimSpace(rest)) != guard {
+		return ""
+	}
+
+	depth := 0
+	for i, line := range lines {
+		switch name, _ := splitDirective(line); name {
+		case "if", "ifdef", "ifndef":
+			depth++
+		case "endif":
+			depth--
+			if depth == 0 {
+				if i == len(lines)-1 {
+					return guard
+				}
+				return ""
+			}
+		}
+	}
+	return ""
+}
+
+func (c *conditional) enter(value int) {
+	c.state = branchInactive
+	if c.parent == branchInactive || c.taken == branchActive {
+		return
+	}
+
+	switch {
+	case value == valueTrue && c.taken == branchInactive:
+		c.state = c.parent
+		c.taken = branchActive
+	case value == valueTrue:
+		c.state = branchMaybe
+		c.taken = branchActive
+	case value == valueUnknown:
+		c.state = branchMaybe
+		c.taken = branchMaybe
+	}
+
+	if c.parent == branchMaybe && c.state != branchInactive {
+		c.state = branchMaybe
+	}
+}
+
+func (tu *translationUnit) condition(name string, expression string, path string, index int) int {
+	switch name {
+	case "ifdef", "ifndef":
+		value := tu.defined(strings.TrimSpace(expression))
+		if name == "ifndef" {
+			value = not(value)
+		}
+		return value
+	}
+
+	e := &evaluator{tu: tu, from: path, index: index}
+	return e.evaluate(expression)
+}
+
+func (tu *translationUnit) defined(name string) int {
+	if m, ok := tu.lookup(name); ok {
+		if !m.known {
+			return valueUnknown
+		}
+		return valueTrue
+	}
+	if isReservedIdentifier(name) {
+		// possibly predefined by the compiler
+		return valueUnknown
+	}
+	return valueFalse
+}
+
+// parseInclude reads the header name of an include directive, expanding a macro if needed.
+func (tu *translationUnit) parseInclude(rest string, from string) (Include, bool) {
+	rest = strings.TrimSpace(rest)
+	for depth := 0; depth < 16; depth++ {
+		if strings.HasPrefix(rest, "\"") {
+			if end := strings.Index(rest[1:], "\""); end >= 0 {
+				return Include{From: from, Name: rest[1 : end+1]}, true
+			}
+			return Include{}, false
+		}
+		if strings.HasPrefix(rest, "<") {
+			if end := strings.Index(rest, ">"); end >= 0 {
+				return Include{From: from, Name: rest[1:end], Angled: true}, true
+			}
+			return Include{}, false
+		}
+
+		m, ok := tu.lookup(identifier(rest))
+		if !ok || !m.known {
+			return Include{}, false
+		}
+		rest = strings.TrimSpace(m.value)
+	}
+	return Include{}, false
+}
+
+func isReservedIdentifier(name string) bool {
+	return strings.HasPrefix(name, "__") || (len(name) > 1 && name[0] == '_' && name[1] >= 'A' && name[1] <= 'Z')
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func identifier(s string) string {
+	i := 0
+	for i < len(s) && isIdentifierChar(s[i]) {
+		i++
+	}
+	return s[:i]
+}
+
+// parseDefine splits "NAME value" into its name and value. Function-like
+// macros are reported with an empty value, they are never expanded.
+func parseDefine(rest string) (string, string, bool) {
+	rest = strings.TrimSpace(rest)
+	name := identifier(rest)
+	if name == "" {
+		return "", "", false
+	}
+	if strings.HasPrefix(rest[len(name):], "(") {
+		return name, "", true
+	}
+	return name, strings.TrimSpace(rest[len(name):]), true
+}
+
+func splitDirective(line string) (string, string) {
+	line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "#"))
+	name := identifier(line)
+	return name, line[len(name):]
+}
+
+// directives returns the preprocessor lines of a file, with comments removed
+// and continuation lines joined. The module and import declarations of C++20
+// are directives too.
+func directives(content []byte) []string {
+	lines := make([]string, 0)
+	var line strings.Builder
+	startOfLine := true
+	isDirective := false
+
+	flush := func() {
+		if isDirective {
+			lines = append(lines, line.String())
+		}
+		line.Reset()
+		startOfLine = true
+		isDirective = false
+	}
+
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+
+		switch {
+		case c == '\\' && i+1 < len(content) && content[i+1] == '\n':
+			i++
+			continue
+		case c == '\\' && i+2 < len(content) && content[i+1] == '\r' && content[i+2] == '\n':
+			i += 2
+			continue
+		case c == '\n':
+			flush()
+			continue
+		case c == '/' && i+1 < len(content) && content[i+1] == '/':
+			for i+1 < len(content) && content[i+1] != '\n' {
+				// a line comment may be continued too
+				if content[i+1] == '\\' && i+2 < len(content) && content[i+2] == '\n' {
+					i++
+				}
+				i++
+			}
+			continue
+		case c == '/' && i+1 < len(content) && content[i+1] == '*':
+			end := strings.Index(string(content[i+2:]), "*/")
+			if end < 0 {
+				i = len(content)
+				continue
+			}
+			i += end + 3
+			line.WriteByte(' ')
+			continue
+		case c == '"' && isDirective:
+			end := skipQuoted(content, i, '"')
+			if end >= len(content) {
+				end = len(content) - 1
+			}
+			line.Write(content[i : end+1])
+			i = end
+			continue
+		case c == '"':
+			i = skipString(content, i)
+			startOfLine = false
+			continue
+		case c == '\'' && !isDirective:
+			// digit separators (1'000) are not character literals
+			if i == 0 || !isIdentifierChar(content[i-1]) {
+				i = skipQuoted(content, i, '\'')
+			}
+			startOfLine = false
+			continue
+		}
+
+		if startOfLine && (c == '#' || isModuleDirective(content[i:])) {
+			isDirective = true
+		}
+		if c != ' ' && c != '\t' && c != '\r' {
+			startOfLine = false
+		}
+		if isDirective {
+			line.WriteByte(c)
+		}
+	}
+	flush()
+
+	return lines
+}
+
+func isModuleDirective(content []byte) bool {
+	for _, keyword := range []string{"module", "import", "export"} {
+		if len(content) > len(keyword) && string(content[:len(keyword)]) == keyword && !isIdentifierChar(content[len(keyword)]) {
+			return true
+		}
+	}
+	return false
+}
+
+func skipQuoted(content []byte, i int, quote byte) int {
+	for i++; i < len(content); i++ {
+		switch content[i] {
+		case '\\':
+			i++
+		case quote, '\n':
+			return i
+		}
+	}
+	return i
+}
+
+// skipString skips a string literal starting at i, including raw strings.
+func skipString(content []byte, i int) int {
+	if i > 0 && content[i-1] == 'R' {
+		open := strings.IndexByte(string(content[i+1:]), '(')
+		if open >= 0 && open <= 16 {
+			terminator := ")" + string(content[i+1:i+1+open]) + "\""
+			end := strings.Index(string(content[i+1+open:]), terminator)
+			if end >= 0 {
+				return i + 1 + open + end + len(terminator) - 1
+			}
+			return len(content)
+		}
+	}
+	return skipQuoted(content, i, '"')
+}
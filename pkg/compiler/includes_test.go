@@ -0,0 +1,284 @@
+package compiler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTree creates files relative to a temporary directory and changes into it.
+func writeTree(t *testing.T, files map[string]string) {
+	t.Helper()
+	root := t.TempDir()
+	for path, content := range files {
+		full := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+}
+
+func scan(t *testing.T, args ...string) ScanResult {
+	t.Helper()
+	gcc := NewCompiler(GCCCompiler)
+	if err := gcc.Parse(args); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	return NewIncludeScanner(gcc).Scan()
+}
+
+func expectFiles(t *testing.T, result ScanResult, expected ...string) {
+	t.Helper()
+	if !reflect.DeepEqual(result.Files, expected) {
+		t.Errorf("Expected files ['%s'], got ['%s']", strings.Join(expected, "', '"), strings.Join(result.Files, "', '"))
+	}
+}
+
+func TestIncludeScannerSearchOrder(t *testing.T) {
+	writeTree(t, map[string]string{
+		"src/main.cpp":          "#include \"local.h\"\n#include <lib.h>\n#include <vector>\n",
+		"src/local.h":           "#pragma once\n",
+		"include/local.h":       "#error wrong header\n",
+		"include/lib.h":         "#include \"detail/impl.h\"\n",
+		"include/detail/impl.h": "",
+		"other/lib.h":           "#error wrong header\n",
+		"unused/unused.h":       "",
+	})
+
+	result := scan(t, "-c", "-Iinclude", "-I", "other", "src/main.cpp", "-o", "main.o")
+	expectFiles(t, result, "src/main.cpp", "src/local.h", "include/lib.h", "include/detail/impl.h")
+	if len(result.Unresolved) != 0 {
+		t.Errorf("Expected no unresolved includes, got %v", result.Unresolved)
+	}
+}
+
+func TestIncludeScannerQuoteAndSystem(t *testing.T) {
+	writeTree(t, map[string]string{
+		"main.c":         "#include \"config.h\"\n#include <sys.h>\n#include <after.h>\n",
+		"quote/config.h": "",
+		"sys/sys.h":      "",
+		"late/after.h":   "",
+		"quote/sys.h":    "#error angled includes skip -iquote\n",
+	})
+
+	result := scan(t, "-c", "-iquote", "quote", "-isystem", "sys", "-idirafter", "late", "main.c")
+	expectFiles(t, result, "main.c", "quote/config.h", "sys/sys.h", "late/after.h")
+}
+
+func TestIncludeScannerIncludeNext(t *testing.T) {
+	writeTree(t, map[string]string{
+		"main.c":        "#include <wrap.h>\n",
+		"first/wrap.h":  "#include_next <wrap.h>\n",
+		"second/wrap.h": "#include_next <wrap.h>\n",
+		"third/wrap.h":  "",
+	})
+
+	result := scan(t, "-c", "-Ifirst", "-Isecond", "-isystem", "third", "main.c")
+	expectFiles(t, result, "main.c", "first/wrap.h", "second/wrap.h", "third/wrap.h")
+}
+
+func TestIncludeScannerConditionals(t *testing.T) {
+	writeTree(t, map[string]string{
+		"main.c": strings.Join([]string{
+			"#define USE_A 1",
+			"#if USE_A",
+			"#include \"a.h\"",
+			"#else",
+			"#include \"b.h\"",
+			"#endif",
+			"#ifdef FEATURE",
+			"#include \"feature.h\"",
+			"#elif defined(OTHER) && OTHER > 2",
+			"#include \"other.h\"",
+			"#endif",
+			"#ifndef NOT_DEFINED",
+			"#include \"c.h\"",
+			"#endif",
+			"#if 0",
+			"#include \"never.h\"",
+			"#endif",
+			"#if defined(_WIN32)",
+			"#include \"windows.h\"",
+			"#else",
+			"#include \"posix.h\"",
+			"#endif",
+			"#if __has_include(\"optional.h\")",
+			"#include \"optional.h\"",
+			"#endif",
+			"#if __has_include(\"missing.h\")",
+			"#include \"missing.h\"",
+			"#endif",
+		}, "\n"),
+		"a.h": "", "b.h": "", "feature.h": "", "other.h": "", "c.h": "",
+		"never.h": "", "windows.h": "", "posix.h": "", "optional.h": "",
+	})
+
+	result := scan(t, "-c", "-DFEATURE", "-D", "OTHER=3", "main.c")
+	expectFiles(t, result, "main.c", "a.h", "feature.h", "c.h", "windows.h", "posix.h", "optional.h")
+	if len(result.Unresolved) != 0 {
+		t.Errorf("Expected no unresolved includes, got %v", result.Unresolved)
+	}
+
+	result = scan(t, "-c", "-D", "OTHER=3", "-UUSE_A", "main.c")
+	expectFiles(t, result, "main.c", "a.h", "other.h", "c.h", "windows.h", "posix.h", "optional.h")
+}
+
+func TestIncludeScannerMacroInclude(t *testing.T) {
+	writeTree(t, map[string]string{
+		"main.c":         "#define CONFIG \"config.h\"\n#define PLATFORM <platform.h>\n#include CONFIG\n#include PLATFORM\n#include UNKNOWN\n",
+		"config.h":       "",
+		"inc/platform.h": "",
+	})
+
+	result := scan(t, "-c", "-Iinc", "main.c")
+	expectFiles(t, result, "main.c", "config.h", "inc/platform.h")
+	if len(result.Unresolved) != 1 || result.Unresolved[0].Name != "UNKNOWN" || !result.Unresolved[0].Computed {
+		t.Errorf("Expected UNKNOWN to be unresolved, got %v", result.Unresolved)
+	}
+}
+
+func TestIncludeScannerCommentsAndStrings(t *testing.T) {
+	writeTree(t, map[string]string{
+		"main.c": strings.Join([]string{
+			"/* #include \"commented.h\"",
+			"#include \"commented.h\" */",
+			"// #include \"commented.h\"",
+			"const char *s = \"/*\";",
+			"#include \"real.h\" // trailing comment",
+			"const char *r = R\"x(/* )x\";",
+			"int n = 1'000;",
+			"  #  include \\",
+			"  \"continued.h\"",
+		}, "\n"),
+		"commented.h": "", "real.h": "", "continued.h": "",
+	})
+
+	result := scan(t, "-c", "main.c")
+	expectFiles(t, result, "main.c", "real.h", "continued.h")
+}
+
+func TestIncludeScannerForcedIncludeAndCycles(t *testing.T) {
+	writeTree(t, map[string]string{
+		"main.c":       "#include \"a.h\"\n",
+		"a.h":          "#include \"b.h\"\n",
+		"b.h":          "#include \"a.h\"\n",
+		"inc/prefix.h": "#define PREFIX 1\n",
+	})
+
+	result := scan(t, "-c", "-Iinc", "-include", "prefix.h", "main.c")
+	expectFiles(t, result, "inc/prefix.h", "main.c", "a.h", "b.h")
+}
+
+func TestIncludeScannerMacroState(t *testing.T) {
+	writeTree(t, map[string]string{
+		"main.c":   "#include \"select.h\"\n#define WANT_B\n#include \"select.h\"\n#include \"once.h\"\n#define AGAIN\n#include \"once.h\"\n",
+		"select.h": "#ifdef WANT_B\n#include \"b.h\"\n#else\n#include \"a.h\"\n#endif\n",
+		"once.h":   "#pragma once\n#ifdef AGAIN\n#include \"never.h\"\n#endif\n",
+		"a.h":      "", "b.h": "", "never.h": "",
+	})
+
+	result := scan(t, "-c", "main.c")
+	expectFiles(t, result, "main.c", "select.h", "a.h", "b.h", "once.h")
+}
+
+func TestIncludeScannerNestedMacroState(t *testing.T) {
+	// the macro is tested by a header included by the header included again
+	writeTree(t, map[string]string{
+		"main.c":    "#include \"wrapper.h\"\n#define WANT_B\n#include \"wrapper.h\"\n",
+		"wrapper.h": "#include \"select.h\"\n",
+		"select.h":  "#ifdef WANT_B\n#include \"b.h\"\n#else\n#include \"a.h\"\n#endif\n",
+		"a.h":       "", "b.h": "",
+	})
+
+	result := scan(t, "-c", "main.c")
+	expectFiles(t, result, "main.c", "wrapper.h", "select.h", "a.h", "b.h")
+}
+
+func TestIncludeScannerLargeTree(t *testing.T) {
+	// headers like the ones of libstdc++: guarded, in conditions the scanner
+	// cannot decide, including each other and defining macros in between
+	const headers = 300
+	files := map[string]string{"main.cpp": ""}
+	for i := 0; i < headers; i++ {
+		var content strings.Builder
+		fmt.Fprintf(&content, "#ifndef _H%d\n#define _H%d 1\n#if __cplusplus >= 201103L\n", i, i)
+		for k := 1; k <= 10; k++ {
+			fmt.Fprintf(&content, "#define _FEATURE_%d_%d 1\n#include <h%d.h>\n", i, k, (i*7+k*13)%headers)
+			fmt.Fprintf(&content, "#ifdef _FEATURE_%d_%d\n#endif\n", (i+k)%headers, k)
+		}
+		content.WriteString("#endif\n#endif\n")
+		files[fmt.Sprintf("inc/h%d.h", i)] = content.String()
+		files["main.cpp"] += fmt.Sprintf("#include <h%d.h>\n", i*37%headers)
+	}
+	writeTree(t, files)
+
+	result := scan(t, "-c", "-isystem", "inc", "main.cpp")
+	if result.Incomplete {
+		t.Fatalf("Expected the scan to finish within its limit")
+	}
+	if len(result.Files) != headers+1 {
+		t.Errorf("Expected %d files, got %d", headers+1, len(result.Files))
+	}
+}
+
+func TestIncludeScannerUnresolved(t *testing.T) {
+	writeTree(t, map[string]string{
+		"main.c": "#include \"missing.h\"\n#include <toolchain.h>\n#ifdef _MSC_VER\n#include \"maybe.h\"\n#endif\n",
+	})
+
+	result := scan(t, "-c", "main.c")
+	expectFiles(t, result, "main.c")
+	expected := []Include{{From: "main.c", Name: "missing.h"}}
+	if !reflect.DeepEqual(result.Unresolved, expected) {
+		t.Errorf("Expected unresolved %v, got %v", expected, result.Unresolved)
+	}
+}
+
+func TestIncludeExpression(t *testing.T) {
+	tu := &translationUnit{scanner: &IncludeScanner{exists: map[string]bool{}}, macros: map[string]macro{
+		"ONE":     {value: "1", known: true},
+		"VERSION": {value: "(ONE * 100 + 2)", known: true},
+		"MAYBE":   {known: false},
+	}}
+
+	cases := map[string]int{
+		"1":                            valueTrue,
+		"0":                            valueFalse,
+		"ONE && !NOPE":                 valueTrue,
+		"VERSION >= 102":               valueTrue,
+		"VERSION > 0x70":               valueFalse,
+		"defined ONE && defined(NOPE)": valueFalse,
+		"defined(MAYBE)":               valueUnknown,
+		"__GNUC__ >= 12":               valueUnknown,
+		"__GNUC__ >= 12 || ONE":        valueTrue,
+		"__GNUC__ >= 12 && NOPE":       valueFalse,
+		"__has_feature(modules)":       valueUnknown,
+		"ONE ? 2 : 0":                  valueTrue,
+		"(1 << 4) == 16 && 7 % 4 == 3": valueTrue,
+		"-1 < 0":                       valueTrue,
+		"'A' == 65":                    valueTrue,
+		"10UL / 0":                     valueUnknown,
+	}
+
+	for expression, expected := range cases {
+		e := &evaluator{tu: tu}
+		if value := e.evaluate(expression); value != expected {
+			t.Errorf("Expected '%s' to evaluate to %d, got %d", expression, expected, value)
+		}
+	}
+}
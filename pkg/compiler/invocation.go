@@ -0,0 +1,110 @@
+package compiler
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// what the compiler is asked to do
+const (
+	ModeCompile      = "compile"      // -c, /c: sources to objects
+	ModeAssemble     = "assemble"     // -S, /FA without /c: sources to assembly
+	ModePreprocess   = "preprocess"   // -E, /E, /P
+	ModeDependencies = "dependencies" // -M, -MM: only the dependencies of the sources
+	ModeSyntax       = "syntax"       // -fsyntax-only, /Zs: only the diagnostics
+	ModeLink         = "link"         // sources and objects to an executable or a library
+	ModeQuery        = "query"        // --version, -print-*, ... no sources at all
+)
+
+// Invocation is what a compiler command line does.
+type Invocation struct {
+	Mode     string
+	Language string // of the first source, "" when unknown
+	Sources  []string
+	// Output is the primary output of the mode, "" for the standard output
+	Output      string
+	SideOutputs []string // every other output, without the depfile
+	Depfile     string   // the dependency file written by -MD, -MMD or -MF
+	// Distributable is set when the invocation can run on an executor: a single
+	// source is compiled, preprocessed to a file or checked without reading the
+	// standard input.
+	Distributable bool
+}
+
+var extensionLanguages = map[string]string{
+	".c":    "c",
+	".i":    "cpp-output",
+	".h":    "c-header",
+	".cc":   "c++",
+	".cp":   "c++",
+	".cxx":  "c++",
+	".cpp":  "c++",
+	".CPP":  "c++",
+	".c++":  "c++",
+	".C":    "c++",
+	".ii":   "c++-cpp-output",
+	".hh":   "c++-header",
+	".H":    "c++-header",
+	".hp":   "c++-header",
+	".hxx":  "c++-header",
+	".hpp":  "c++-header",
+	".HPP":  "c++-header",
+	".h++":  "c++-header",
+	".tcc":  "c++-header",
+	".cppm": "c++-module",
+	".ccm":  "c++-module",
+	".cxxm": "c++-module",
+	".c++m": "c++-module",
+	".ixx":  "c++-module",
+	".m":    "objective-c",
+	".mi":   "objective-c-cpp-output",
+	".mm":   "objective-c++",
+	".M":    "objective-c++",
+	".mii":  "objective-c++-cpp-output",
+	".s":    "assembler",
+	".S":    "assembler-with-cpp",
+	".sx":   "assembler-with-cpp",
+	".cu":   "cuda",
+	".pcm":  "c++-module-bmi", // a clang BMI compiled to an object
+}
+
+// sourceLanguage returns the language of a source file by its extension.
+func sourceLanguage(path string) (string, bool) {
+	language, ok := extensionLanguages[filepath.Ext(path)]
+	return language, ok
+}
+
+func distributable(invocation Invocation) bool {
+	switch invocation.Mode {
+	case ModeCompile, ModeAssemble, ModeSyntax:
+	case ModePreprocess:
+		// the preprocessed output is usually piped to another tool
+		if invocation.Output == "" {
+			return false
+		}
+	default:
+		return false
+	}
+	return len(invocation.Sources) == 1 && invocation.Sources[0] != "-"
+}
+
+// sideOutputs returns the outputs of the arguments other than the primary output and the depfile.
+func sideOutputs(arguments []GCCArgument, invocation Invocation) []string {
+	outputs := make([]string, 0)
+	for _, arg := range arguments {
+		if !arg.IsOutput() {
+			continue
+		}
+		output := arg.Parameter()
+		if output != invocation.Output && output != invocation.Depfile {
+			outputs = append(outputs, output)
+		}
+	}
+	return outputs
+}
+
+// replaceExtension returns the file name of the path with another extension.
+func replaceExtension(path string, extension string) string {
+	base := filepath.Base(strings.ReplaceAll(path, "\\", "/"))
+	return strings.TrimSuffix(base, filepath.Ext(base)) + extension
+}
@@ -0,0 +1,67 @@
+package compiler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInvocation(t *testing.T) {
+	cases := []struct {
+		compiler string
+		args     []string
+		expected Invocation
+	}{
+		{GCCCompiler, []string{"-c", "a.cpp", "-o", "a.o"},
+			Invocation{Mode: ModeCompile, Language: "c++", Sources: []string{"a.cpp"}, Output: "a.o", SideOutputs: []string{}, Distributable: true}},
+		{GCCCompiler, []string{"-c", "src/a.c"},
+			Invocation{Mode: ModeCompile, Language: "c", Sources: []string{"src/a.c"}, Output: "a.o", SideOutputs: []string{}, Distributable: true}},
+		{GCCCompiler, []string{"-c", "-x", "c++", "a.inl", "-MD", "-o", "out/a.o"},
+			Invocation{Mode: ModeCompile, Language: "c++", Sources: []string{"a.inl"}, Output: "out/a.o", SideOutputs: []string{}, Depfile: "out/a.d", Distributable: true}},
+		{GCCCompiler, []string{"-c", "a.c", "-MMD", "-MF", "deps/a.d", "-aux-info", "a.txt", "-o", "a.o"},
+			Invocation{Mode: ModeCompile, Language: "c", Sources: []string{"a.c"}, Output: "a.o", SideOutputs: []string{"a.txt"}, Depfile: "deps/a.d", Distributable: true}},
+		{GCCCompiler, []string{"-c", "a.c", "b.c"},
+			Invocation{Mode: ModeCompile, Language: "c", Sources: []string{"a.c", "b.c"}, Output: "a.o", SideOutputs: []string{"b.o"}}},
+		{GCCCompiler, []string{"-c", "-x", "c", "-", "-o", "a.o"},
+			Invocation{Mode: ModeCompile, Language: "c", Sources: []string{"-"}, Output: "a.o", SideOutputs: []string{}}},
+		{GCCCompiler, []string{"-E", "a.c"},
+			Invocation{Mode: ModePreprocess, Language: "c", Sources: []string{"a.c"}, SideOutputs: []string{}}},
+		{GCCCompiler, []string{"-E", "a.c", "-o", "a.i"},
+			Invocation{Mode: ModePreprocess, Language: "c", Sources: []string{"a.c"}, Output: "a.i", SideOutputs: []string{}, Distributable: true}},
+		{GCCCompiler, []string{"-M", "a.c"},
+			Invocation{Mode: ModeDependencies, Language: "c", Sources: []string{"a.c"}, SideOutputs: []string{}}},
+		{GCCCompiler, []string{"-S", "a.c"},
+			Invocation{Mode: ModeAssemble, Language: "c", Sources: []string{"a.c"}, Output: "a.s", SideOutputs: []string{}, Distributable: true}},
+		{GCCCompiler, []string{"-fsyntax-only", "a.cc"},
+			Invocation{Mode: ModeSyntax, Language: "c++", Sources: []string{"a.cc"}, SideOutputs: []string{}, Distributable: true}},
+		{GCCCompiler, []string{"a.o", "b.o", "-lm", "-o", "app"},
+			Invocation{Mode: ModeLink, Sources: []string{}, Output: "app", SideOutputs: []string{}}},
+		{GCCCompiler, []string{"--version"},
+			Invocation{Mode: ModeQuery, Sources: []string{}, SideOutputs: []string{}}},
+		{GCCCompiler, []string{"-print-file-name=libc.so"},
+			Invocation{Mode: ModeQuery, Sources: []string{}, SideOutputs: []string{}}},
+		{MSVCCompiler, []string{"/c", "a.cpp", "/Fo:a.obj", "/Zi", "/Fdvc.pdb"},
+			Invocation{Mode: ModeCompile, Language: "c++", Sources: []string{"a.cpp"}, Output: "a.obj", SideOutputs: []string{"vc.pdb"}, Distributable: true}},
+		{MSVCCompiler, []string{"/c", "/TP", "a.c"},
+			Invocation{Mode: ModeCompile, Language: "c++", Sources: []string{"a.c"}, Output: "a.obj", SideOutputs: []string{}, Distributable: true}},
+		{MSVCCompiler, []string{"/P", "a.c"},
+			Invocation{Mode: ModePreprocess, Language: "c", Sources: []string{"a.c"}, Output: "a.i", SideOutputs: []string{}, Distributable: true}},
+		{MSVCCompiler, []string{"/Zs", "a.cpp"},
+			Invocation{Mode: ModeSyntax, Language: "c++", Sources: []string{"a.cpp"}, SideOutputs: []string{}, Distributable: true}},
+		{MSVCCompiler, []string{"a.cpp", "/link", "/OUT:app.exe"},
+			Invocation{Mode: ModeLink, Language: "c++", Sources: []string{"a.cpp"}, Output: "app.exe", SideOutputs: []string{"a.obj"}}},
+		{MSVCCompiler, []string{"a.obj", "b.obj"},
+			Invocation{Mode: ModeLink, Sources: []string{}, Output: "a.exe", SideOutputs: []string{}}},
+		{MSVCCompiler, []string{"/?"},
+			Invocation{Mode: ModeQuery, Sources: []string{}, SideOutputs: []string{}}},
+	}
+
+	for _, tc := range cases {
+		c := NewCompiler(tc.compiler)
+		if err := c.Parse(tc.args); err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		if invocation := c.Invocation(); !reflect.DeepEqual(invocation, tc.expected) {
+			t.Errorf("Expected %v to be %+v, got %+v", tc.args, tc.expected, invocation)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package compiler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/Zeeno-atl/all-build/internal/utils"
+)
+
+// moduleCommand marks the implicit arguments of the BMIs (.gcm, .pcm, .ifc)
+// read and written by a module unit
+const moduleCommand = "<module>"
+
+// moduleUnit is what a source declares in its module preamble.
+type moduleUnit struct {
+	name    string   // the module of the unit, "M" or "M:P" for a partition, "" for none
+	exports bool     // the unit is an interface or a partition, so it writes a BMI
+	imports []string // module names, "<header>" and "\"header\"" header units
+}
+
+var moduleDirective = regexp.MustCompile(`^(export\s+)?(module|import)\s*([\w.:]*|<[^>]*>|"[^"]*")\s*(\[\[.*\]\])?\s*;`)
+
+// scanModuleUnit reads the module and import declarations of a source. They
+// are directives since C++20, at the start of a line like #include.
+func scanModuleUnit(path string) (moduleUnit, bool) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return moduleUnit{}, false
+	}
+
+	unit := moduleUnit{}
+	for _, line := range directives(content) {
+		match := moduleDirective.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		exported, keyword, name := match[1] != "", match[2], match[3]
+		switch {
+		case keyword == "module" && name != "" && !strings.HasPrefix(name, ":"):
+			// module; and module :private; do not name a module
+			unit.name = name
+			unit.exports = exported || strings.Contains(name, ":")
+			if !unit.exports {
+				// an implementation unit imports its interface
+				unit.imports = append(unit.imports, name)
+			}
+		case keyword == "import" && strings.HasPrefix(name, ":"):
+			// a partition of the own module
+			module, _, _ := strings.Cut(unit.name, ":")
+			unit.imports = append(unit.imports, module+name)
+		case keyword == "import" && name != "":
+			unit.imports = append(unit.imports, name)
+		}
+	}
+	return unit, true
+}
+
+// usesModules tells whether the invocation reads or writes BMIs.
+func usesModules(arguments []GCCArgument, invocation Invocation) bool {
+	return invocation.Language == "c++-module" || invocation.Language == "c++-module-bmi" ||
+		utils.ContainsIf(arguments, func(arg GCCArgument) bool { return arg.command == moduleCommand })
+}
+
+func isHeaderUnit(name string) bool {
+	return strings.HasPrefix(name, "<") || strings.HasPrefix(name, "\"")
+}
+
+// moduleMapper maps the modules to their BMIs like GCC does, by the
+// -fmodule-mapper file or by the default names in gcm.cache.
+type moduleMapper struct {
+	root    string
+	modules map[string]string
+	file    bool // only the modules listed in the file are mapped
+}
+
+func newModuleMapper() *moduleMapper {
+	return &moduleMapper{root: "gcm.cache", modules: make(map[string]string)}
+}
+
+// parseModuleMapper reads the lines "module-name bmi" of a mapper file,
+// "$root dir" sets the directory of the relative BMIs.
+func parseModuleMapper(content string) *moduleMapper {
+	m := &moduleMapper{root: ".", modules: make(map[string]string), file: true}
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] == "$root" {
+			m.root = fields[1]
+		} else {
+			m.modules[fields[0]] = fields[1]
+		}
+	}
+	return m
+}
+
+// bmi returns the BMI of a module, or of the header unit of a header path.
+func (m *moduleMapper) bmi(name string) string {
+	if bmi, ok := m.modules[name]; ok {
+		if filepath.IsAbs(bmi) {
+			return bmi
+		}
+		return filepath.Join(m.root, bmi)
+	}
+	if m.file {
+		return ""
+	}
+	return filepath.Join(m.root, gccModuleName(name)+".gcm")
+}
+
+// gccModuleName is the file name of a module in gcm.cache: ':' of the
+// partitions is '-', header units are their paths with the leading '.' or '/'
+// turned into ','.
+func gccModuleName(name string) string {
+	if !strings.HasPrefix(name, "/") && !strings.HasPrefix(name, ".") {
+		return strings.ReplaceAll(name, ":", "-")
+	}
+	if strings.HasPrefix(name, "/") {
+		return "," + name
+	}
+	if strings.HasPrefix(name, "..") {
+		return ",," + name[2:]
+	}
+	return "," + name[1:]
+}
+
+// headerUnitName is the name GCC gives the header unit of a path.
+func headerUnitName(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	path = filepath.Clean(path)
+	if strings.HasPrefix(path, "..") {
+		return path
+	}
+	return "./" + path
+}
+
+// addModuleFiles adds the BMIs of C++20 modules (-fmodules-ts) as implicit
+// inputs and outputs. The BMIs are named by the -fmodule-mapper file, or are
+// in gcm.cache, which is shipped whole when a header unit cannot be found.
+func (c *GCC) addModuleFiles() {
+	mapper := newModuleMapper()
+	for i, arg := range c.arguments {
+		if arg.command != "-fmodule-mapper=" {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(c.dir, arg.Parameter()))
+		if err != nil {
+			// a program, a socket or a file with an ?ident
+			c.arguments[i].argType = ArgumentTypeValue
+			continue
+		}
+		mapper = parseModuleMapper(string(content))
+	}
+	if !c.hasFlag("-fmodules-ts", "-fmodules") {
+		return
+	}
+
+	added := make(map[string]bool)
+	implicit := func(argType string, path string) {
+		if path == "" || added[path] {
+			return
+		}
+		if _, err := os.Stat(filepath.Join(c.dir, path)); err != nil && argType == ArgumentTypeInput {
+			return
+		}
+		added[path] = true
+		c.arguments = append(c.arguments, GCCArgument{command: moduleCommand, argType: argType, parameter: path, implicit: true})
+	}
+
+	sources, languages := c.sources()
+	scanner := NewIncludeScanner(c)
+	for i, source := range sources {
+		if source == "-" {
+			continue
+		}
+		if strings.HasSuffix(languages[i], "-header") || c.hasFlag("-fmodule-header") || c.hasFlagPrefix("-fmodule-header=") {
+			implicit(ArgumentTypeOutput, mapper.bmi(headerUnitName(source)))
+			continue
+		}
+
+		unit, ok := scanModuleUnit(filepath.Join(c.dir, source))
+		if !ok {
+			continue
+		}
+		if unit.exports {
+			implicit(ArgumentTypeOutput, mapper.bmi(unit.name))
+		}
+		for _, name := range unit.imports {
+			if !isHeaderUnit(name) {
+				implicit(ArgumentTypeInput, mapper.bmi(name))
+				continue
+			}
+			include := Include{From: source, Name: name[1 : len(name)-1], Angled: name[0] == '<'}
+			if path, _, ok := scanner.resolve(include, 0); ok {
+				implicit(ArgumentTypeInput, mapper.bmi(headerUnitName(path)))
+			} else if bmi, ok := mapper.modules[include.Name]; ok {
+				implicit(ArgumentTypeInput, filepath.Join(mapper.root, bmi))
+			} else if !mapper.file {
+				// a header of the toolchain
+				implicit(ArgumentTypeInput, mapper.root)
+			}
+		}
+	}
+}
+
+// hasFlagPrefix tells whether an option starting with prefix is on the command line.
+func (c *GCC) hasFlagPrefix(prefix string) bool {
+	for _, arg := range c.arguments {
+		if !arg.implicit && strings.HasPrefix(arg.command, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// addModuleFiles adds the BMI written by -fmodule-output next to the object.
+// The BMIs read are named by -fmodule-file and -fprebuilt-module-path.
+func (c *Clang) addModuleFiles() {
+	if !c.hasFlag("-fmodule-output") {
+		return
+	}
+	output := c.Invocation().Output
+	if output == "" {
+		return
+	}
+	c.arguments = append(c.arguments, GCCArgument{command: moduleCommand, argType: ArgumentTypeOutput, parameter: strings.TrimSuffix(output, filepath.Ext(output)) + ".pcm", implicit: true})
+}
+
+// addModuleFiles adds the .ifc written by a module interface or a header unit,
+// named after the module or the header, in the directory of /ifcOutput.
+func (c *MSVC) addModuleFiles() {
+	sources, languages := c.sources()
+	if c.mode(sources) != ModeCompile {
+		return
+	}
+
+	dir := ""
+	if ifc := c.option("ifcOutput"); len(ifc) > 0 {
+		if !isDirectory(ifc[0].parameter) {
+			return
+		}
+		dir = ifc[0].parameter
+		ifc[0].argType = ArgumentTypeValue
+	}
+
+	for i, source := range sources {
+		name := ""
+		switch {
+		case languages[i] == "c++-header":
+			name = filepath.Base(strings.ReplaceAll(source, "\\", "/")) + ".ifc"
+		default:
+			unit, _ := scanModuleUnit(filepath.Join(c.dir, source))
+			switch {
+			case unit.exports:
+				name = strings.ReplaceAll(unit.name, ":", "-") + ".ifc"
+			case languages[i] == "c++-module" || c.hasFlag("interface") || c.hasFlag("internalPartition"):
+				name = replaceExtension(source, ".ifc")
+			}
+		}
+		if name != "" {
+			c.arguments = append(c.arguments, GCCArgument{command: moduleCommand, argType: ArgumentTypeOutput, parameter: dir + name, implicit: true})
+		}
+	}
+}
+
+// writeModuleMapper writes the -fmodule-mapper file again with the absolute
+// paths of the module names and BMIs chrooted.
+func writeModuleMapper(arguments []GCCArgument) error {
+	for _, arg := range arguments {
+		if arg.command != "-fmodule-mapper=" || !arg.IsInput() || arg.basePath == "" {
+			continue
+		}
+		content, err := os.ReadFile(arg.Parameter())
+		if err != nil {
+			return fmt.Errorf("could not read module mapper: %v", err)
+		}
+
+		lines := strings.Split(string(content), "\n")
+		for i, line := range lines {
+			fields := strings.Fields(line)
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			for j, field := range fields {
+				if filepath.IsAbs(field) {
+					fields[j] = filepath.Join(arg.basePath, field)
+				}
+			}
+			lines[i] = strings.Join(fields, " ")
+		}
+
+		if err := os.WriteFile(arg.Parameter(), []byte(strings.Join(lines, "\n")), 0644); err != nil {
+			return fmt.Errorf("could not write module mapper: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package compiler
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScanModuleUnit(t *testing.T) {
+	writeTree(t, map[string]string{
+		"a.cppm": "module;\n#include <cstdio>\nexport module a:part;\nimport :impl;\nexport import b.c;\nimport <vector>;\n/*\nimport hidden;\n*/\nimport \"local.h\";\nconst char *s = \"import quoted;\";\n",
+		"b.cpp":  "module b [[deprecated]];\nimport std;\nint import_ = 1;\n",
+	})
+
+	cases := []struct {
+		path     string
+		expected moduleUnit
+	}{
+		{"a.cppm", moduleUnit{name: "a:part", exports: true, imports: []string{"a:impl", "b.c", "<vector>", "\"local.h\""}}},
+		{"b.cpp", moduleUnit{name: "b", imports: []string{"b", "std"}}},
+	}
+	for _, tc := range cases {
+		unit, ok := scanModuleUnit(tc.path)
+		if !ok || !reflect.DeepEqual(unit, tc.expected) {
+			t.Errorf("%s: expected %+v, got %+v", tc.path, tc.expected, unit)
+		}
+	}
+}
+
+func TestGCCModules(t *testing.T) {
+	writeTree(t, map[string]string{
+		"a.cppm":                  "export module a;\nimport b;\nimport \"inc/h.h\";\nimport <vector>;\n",
+		"inc/h.h":                 "",
+		"gcm.cache/b.gcm":         "",
+		"gcm.cache/,/inc/h.h.gcm": "",
+		"header.h":                "",
+		"mapped.cpp":              "module a;\nimport /abs;\n",
+		"map.txt":                 "$root /cache\na a.gcm\n",
+	})
+
+	cases := []struct {
+		args    []string
+		inputs  []string
+		outputs []string
+	}{
+		{[]string{"-c", "-fmodules-ts", "a.cppm", "-o", "a.o"}, []string{"a.cppm", "gcm.cache/b.gcm", "gcm.cache/,/inc/h.h.gcm", "gcm.cache"}, []string{"a.o", "gcm.cache/a.gcm"}},
+		{[]string{"-c", "-fmodules-ts", "-fmodule-only", "a.cppm"}, []string{"a.cppm", "gcm.cache/b.gcm", "gcm.cache/,/inc/h.h.gcm", "gcm.cache"}, []string{"gcm.cache/a.gcm"}},
+		{[]string{"-c", "-fmodules-ts", "-fmodule-header", "header.h"}, []string{"header.h"}, []string{"gcm.cache/,/header.h.gcm"}},
+		{[]string{"-c", "-fmodules-ts", "-fmodule-header=user", "header.h", "-o", "header.o"}, []string{"header.h"}, []string{"gcm.cache/,/header.h.gcm"}},
+		{[]string{"-c", "-fmodules-ts", "-x", "c++-header", "header.h"}, []string{"header.h"}, []string{"gcm.cache/,/header.h.gcm"}},
+		{[]string{"-c", "-fmodules-ts", "-fmodule-mapper=map.txt", "mapped.cpp", "-o", "m.o"}, []string{"map.txt", "mapped.cpp"}, []string{"m.o"}},
+		{[]string{"-c", "-fmodule-mapper=|mapper", "a.cppm", "-o", "a.o"}, []string{"a.cppm"}, []string{"a.o"}},
+	}
+	for _, tc := range cases {
+		gcc := NewCompiler(GCCCompiler)
+		if err := gcc.Parse(tc.args); err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		if inputs := GetInputs(gcc); !reflect.DeepEqual(inputs, tc.inputs) {
+			t.Errorf("%v: expected inputs ['%s'], got ['%s']", tc.args, strings.Join(tc.inputs, "', '"), strings.Join(inputs, "', '"))
+		}
+		if outputs := GetOutputs(gcc); !reflect.DeepEqual(outputs, tc.outputs) {
+			t.Errorf("%v: expected outputs ['%s'], got ['%s']", tc.args, strings.Join(tc.outputs, "', '"), strings.Join(outputs, "', '"))
+		}
+		if command := GetCommand(gcc); !reflect.DeepEqual(command, tc.args) {
+			t.Errorf("Expected command ['%s'], got ['%s']", strings.Join(tc.args, "', '"), strings.Join(command, "', '"))
+		}
+	}
+}
+
+func TestWriteModuleMapper(t *testing.T) {
+	writeTree(t, map[string]string{
+		"root/map.txt": "# modules\n$root /cache\na a.gcm\n/usr/include/h.h /bmi/h.gcm\n",
+		"root/a.cpp":   "",
+	})
+
+	gcc := NewCompiler(GCCCompiler)
+	gcc.SetWorkingDirectory("root")
+	gcc.Parse([]string{"-c", "-fmodules-ts", "-fmodule-mapper=map.txt", "a.cpp", "-o", "a.o"})
+	gcc.Chroot("root")
+	if err := gcc.WriteResponseFiles(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, _ := os.ReadFile("root/map.txt")
+	expected := "# modules\n$root root/cache\na a.gcm\nroot/usr/include/h.h root/bmi/h.gcm\n"
+	if string(content) != expected {
+		t.Errorf("Expected mapper %q, got %q", expected, content)
+	}
+}
+
+func TestClangModules(t *testing.T) {
+	cases := []struct {
+		args     []string
+		inputs   []string
+		outputs  []string
+		chrooted []string
+	}{
+		{[]string{"-std=c++20", "--precompile", "a.cppm"}, []string{"a.cppm"}, []string{"a.pcm"},
+			[]string{"-std=c++20", "--precompile", "/r/a.cppm"}},
+		{[]string{"-c", "-fmodule-output", "a.cppm", "-o", "out/a.o"}, []string{"a.cppm"}, []string{"out/a.o", "out/a.pcm"},
+			[]string{"-c", "-fmodule-output", "/r/a.cppm", "-o", "/r/out/a.o"}},
+		{[]string{"-c", "-fmodule-output=a.pcm", "-fmodule-file=b=bmi/b.pcm", "-fmodule-file=c.pcm", "-fprebuilt-module-path=bmi", "a.cppm", "-o", "a.o"},
+			[]string{"bmi/b.pcm", "c.pcm", "bmi", "a.cppm"}, []string{"a.pcm", "a.o"},
+			[]string{"-c", "-fmodule-output=/r/a.pcm", "-fmodule-file=b=/r/bmi/b.pcm", "-fmodule-file=/r/c.pcm", "-fprebuilt-module-path=/r/bmi", "/r/a.cppm", "-o", "/r/a.o"}},
+		{[]string{"-c", "a.pcm", "-o", "a.o"}, []string{"a.pcm"}, []string{"a.o"},
+			[]string{"-c", "/r/a.pcm", "-o", "/r/a.o"}},
+	}
+	for _, tc := range cases {
+		clang := NewCompiler(ClangCompiler)
+		if err := clang.Parse(tc.args); err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		if inputs := GetInputs(clang); !reflect.DeepEqual(inputs, tc.inputs) {
+			t.Errorf("%v: expected inputs ['%s'], got ['%s']", tc.args, strings.Join(tc.inputs, "', '"), strings.Join(inputs, "', '"))
+		}
+		if outputs := GetOutputs(clang); !reflect.DeepEqual(outputs, tc.outputs) {
+			t.Errorf("%v: expected outputs ['%s'], got ['%s']", tc.args, strings.Join(tc.outputs, "', '"), strings.Join(outputs, "', '"))
+		}
+		if invocation := clang.Invocation(); invocation.Mode != ModeCompile || !invocation.Distributable {
+			t.Errorf("%v: expected a distributable compilation, got %+v", tc.args, invocation)
+		}
+		clang.Chroot("/r")
+		if command := GetCommand(clang); !reflect.DeepEqual(command, tc.chrooted) {
+			t.Errorf("Expected command ['%s'], got ['%s']", strings.Join(tc.chrooted, "', '"), strings.Join(command, "', '"))
+		}
+	}
+}
+
+func TestMSVCModules(t *testing.T) {
+	writeTree(t, map[string]string{
+		"a.ixx":    "export module a:part;\n",
+		"b.cpp":    "export module b;\n",
+		"impl.cpp": "module b;\n",
+		"header.h": "",
+	})
+
+	cases := []struct {
+		args     []string
+		inputs   []string
+		outputs  []string
+		chrooted []string
+	}{
+		{[]string{"/c", "/std:c++20", "a.ixx"}, []string{"a.ixx"}, []string{"a.obj", "a-part.ifc"},
+			[]string{"/c", "/std:c++20", "/r/a.ixx"}},
+		{[]string{"/c", "/interface", "/ifcOutput", "bmi/", "/reference", "c=c.ifc", "b.cpp"}, []string{"c.ifc", "b.cpp"}, []string{"b.obj", "bmi/b.ifc"},
+			[]string{"/c", "/interface", "/ifcOutput", "bmi/", "/reference", "c=/r/c.ifc", "/r/b.cpp"}},
+		{[]string{"/c", "/ifcOnly", "/ifcOutput", "out.ifc", "b.cpp"}, []string{"b.cpp"}, []string{"out.ifc"},
+			[]string{"/c", "/ifcOnly", "/ifcOutput", "/r/out.ifc", "/r/b.cpp"}},
+		{[]string{"/c", "/exportHeader", "header.h"}, []string{"header.h"}, []string{"header.obj", "header.h.ifc"},
+			[]string{"/c", "/exportHeader", "/r/header.h"}},
+		{[]string{"/c", "/ifcSearchDir", "bmi", "/headerUnit", "header.h=header.h.ifc", "impl.cpp"}, []string{"bmi", "header.h.ifc", "impl.cpp"}, []string{"impl.obj"},
+			[]string{"/c", "/ifcSearchDir", "/r/bmi", "/headerUnit", "header.h=/r/header.h.ifc", "/r/impl.cpp"}},
+	}
+	for _, tc := range cases {
+		msvc := NewCompiler(MSVCCompiler)
+		if err := msvc.Parse(tc.args); err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		if inputs := GetInputs(msvc); !reflect.DeepEqual(inputs, tc.inputs) {
+			t.Errorf("%v: expected inputs ['%s'], got ['%s']", tc.args, strings.Join(tc.inputs, "', '"), strings.Join(inputs, "', '"))
+		}
+		if outputs := GetOutputs(msvc); !reflect.DeepEqual(outputs, tc.outputs) {
+			t.Errorf("%v: expected outputs ['%s'], got ['%s']", tc.args, strings.Join(tc.outputs, "', '"), strings.Join(outputs, "', '"))
+		}
+		msvc.Chroot("/r")
+		if command := GetCommand(msvc); !reflect.DeepEqual(command, tc.chrooted) {
+			t.Errorf("Expected command ['%s'], got ['%s']", strings.Join(tc.chrooted, "', '"), strings.Join(command, "', '"))
+		}
+	}
+}
+
+func TestPreprocessModules(t *testing.T) {
+	cases := []struct {
+		compiler string
+		args     []string
+	}{
+		{GCCCompiler, []string{"-c", "-fmodules-ts", "a.cpp", "-o", "a.o"}},
+		{ClangCompiler, []string{"-c", "a.cppm", "-o", "a.o"}},
+		{ClangCompiler, []string{"-c", "-fmodule-file=b=b.pcm", "a.cpp", "-o", "a.o"}},
+		{MSVCCompiler, []string{"/c", "/interface", "a.cpp"}},
+	}
+	for _, tc := range cases {
+		c := NewCompiler(tc.compiler)
+		c.Parse(tc.args)
+		if _, _, err := c.Preprocess("a.i", false); err == nil {
+			t.Errorf("%v: expected an error", tc.args)
+		}
+	}
+}
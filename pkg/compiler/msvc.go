@@ -1,99 +1,431 @@
 package compiler
 
 import (
+	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/Zeeno-atl/all-build/internal/utils"
 )
 
+// msvcOptions is the cl.exe option model. Every option may start with "/" or "-".
+func msvcOptions() []option {
+	options := []option{
+		// Outputs, which may also be written as /Fo:path or /Fo: path
+		{name: "Fo", value: valueJoined, argType: ArgumentTypeOutput},
+		{name: "Fe", value: valueJoined, argType: ArgumentTypeOutput},
+		{name: "Fd", value: valueJoined, argType: ArgumentTypeOutput},
+		{name: "Fa", value: valueJoined, argType: ArgumentTypeOutput},
+		{name: "Fi", value: valueJoined, argType: ArgumentTypeOutput},
+		{name: "Fm", value: valueJoined, argType: ArgumentTypeOutput},
+		{name: "FR", value: valueJoined, argType: ArgumentTypeOutput},
+		{name: "Fr", value: valueJoined, argType: ArgumentTypeOutput},
+		{name: "Fo:", value: valueJoined | valueSeparate, argType: ArgumentTypeOutput},
+		{name: "Fe:", value: valueJoined | valueSeparate, argType: ArgumentTypeOutput},
+		{name: "Fd:", value: valueJoined | valueSeparate, argType: ArgumentTypeOutput},
+		{name: "Fa:", value: valueJoined | valueSeparate, argType: ArgumentTypeOutput},
+		{name: "Fi:", value: valueJoined | valueSeparate, argType: ArgumentTypeOutput},
+		{name: "Fm:", value: valueJoined | valueSeparate, argType: ArgumentTypeOutput},
+		{name: "sourceDependencies", value: valueSeparate, argType: ArgumentTypeOutput},
+		{name: "sourceDependencies:directives", value: valueSeparate, argType: ArgumentTypeOutput},
+
+		// Precompiled headers, /Fp is an input or an output depending on /Yu and /Yc
+		{name: "Fp", value: valueJoined, argType: ArgumentTypeInput},
+		{name: "Fp:", value: valueJoined | valueSeparate, argType: ArgumentTypeInput},
+		{name: "Yu", value: valueJoined},
+		{name: "Yc", value: valueJoined},
+		{name: "Yl", value: valueJoined},
+
+		// Preprocessor
+		{name: "I", value: valueJoined | valueSeparate, argType: ArgumentTypeInput},
+		{name: "external:I", value: valueJoined | valueSeparate, argType: ArgumentTypeInput},
+		{name: "external:env:", value: valueJoined},
+		{name: "AI", value: valueJoined | valueSeparate, argType: ArgumentTypeInput},
+		{name: "FI", value: valueJoined | valueSeparate, argType: ArgumentTypeInput},
+		{name: "FU", value: valueJoined | valueSeparate, argType: ArgumentTypeInput},
+		{name: "D", value: valueJoined | valueSeparate},
+		{name: "U", value: valueJoined | valueSeparate},
+
+		// C++20 modules, /ifcOutput may also be a directory
+		{name: "ifcOutput", value: valueSeparate, argType: ArgumentTypeOutput},
+		{name: "ifcSearchDir", value: valueSeparate, argType: ArgumentTypeInput},
+		{name: "ifcMap", value: valueSeparate, argType: ArgumentTypeInput},
+		{name: "reference", value: valueSeparate, argType: ArgumentTypeInput, keyed: true},
+		{name: "headerUnit", value: valueSeparate, argType: ArgumentTypeInput, keyed: true},
+		{name: "headerUnit:quote", value: valueSeparate, argType: ArgumentTypeInput, keyed: true},
+		{name: "headerUnit:angle", value: valueSeparate, argType: ArgumentTypeInput, keyed: true},
+		{name: "headerName:quote", value: valueSeparate, argType: ArgumentTypeInput},
+		{name: "headerName:angle", value: valueSeparate, argType: ArgumentTypeInput},
+
+		// Sources of an explicit language
+		{name: "Tp", value: valueJoined | valueSeparate, argType: ArgumentTypeInput},
+		{name: "Tc", value: valueJoined | valueSeparate, argType: ArgumentTypeInput},
+
+		// Flags sharing a prefix with an option above
+		{name: "FA"},
+		{name: "FAc"},
+		{name: "FAs"},
+		{name: "FAcs"},
+		{name: "FAu"},
+		{name: "FC"},
+		{name: "FS"},
+		{name: "Fx"},
+		{name: "Y-"},
+		{name: "TP"},
+		{name: "TC"},
+		{name: "sourceDependencies-"},
+	}
+
+	return withMSVCPrefixes(options)
+}
+
+// withMSVCPrefixes spells the options both with "/" and "-".
+func withMSVCPrefixes(options []option) []option {
+	prefixed := make([]option, 0, 2*len(options))
+	for _, o := range options {
+		for _, prefix := range []string{"/", "-"} {
+			o := o
+			o.name = prefix + o.name
+			prefixed = append(prefixed, o)
+		}
+	}
+	return prefixed
+}
+
+// msvcLinkerOptions are the linker options after /link, which are case insensitive.
+var msvcLinkerOptions = []option{
+	{name: "/OUT:", argType: ArgumentTypeOutput},
+	{name: "/PDB:", argType: ArgumentTypeOutput},
+	{name: "/IMPLIB:", argType: ArgumentTypeOutput},
+	{name: "/MAP:", argType: ArgumentTypeOutput},
+	{name: "/LIBPATH:", argType: ArgumentTypeInput},
+	{name: "/DEF:", argType: ArgumentTypeInput},
+}
+
+// isMSVCOption tells the unknown options from the sources. An absolute path on
+// a unix machine starts with "/" too, but unlike an option it has more slashes.
+func isMSVCOption(arg string) bool {
+	if strings.HasPrefix(arg, "-") {
+		return true
+	}
+	return strings.HasPrefix(arg, "/") && !strings.Contains(arg[1:], "/")
+}
+
+// linkerCommand normalises a linker option for a case insensitive comparison.
+func linkerCommand(arg string) string {
+	normalised := strings.ToUpper(arg)
+	if strings.HasPrefix(normalised, "-") {
+		normalised = "/" + normalised[1:]
+	}
+	return normalised
+}
+
+func isMSVCSource(path string) bool {
+	return utils.Contains([]string{".c", ".cc", ".cpp", ".cxx", ".c++", ".ixx", ".cppm"}, strings.ToLower(filepath.Ext(path)))
+}
+
+func parseLinkerArguments(args []token) []GCCArgument {
+	arguments := make([]GCCArgument, 0)
+	for _, t := range args {
+		arg := t.value
+		normalised := linkerCommand(arg)
+		argument := GCCArgument{command: arg, response: t.response}
+		if t.file != "" {
+			argument = GCCArgument{command: responseCommand, argType: ArgumentTypeInput, parameter: t.file, response: t.response}
+		} else if !isMSVCOption(arg) {
+			argument = GCCArgument{parameter: arg, response: t.response}
+		}
+		for _, o := range msvcLinkerOptions {
+			if t.file == "" && strings.HasPrefix(normalised, o.name) {
+				argument = GCCArgument{command: arg[:len(o.name)], parameter: arg[len(o.name):], argType: o.argType, response: t.response}
+				break
+			}
+		}
+		arguments = append(arguments, argument)
+	}
+	return arguments
+}
+
 type MSVC struct {
 	ICompiler
 
-	inputs  []string
-	outputs []string
+	arguments []GCCArgument
+	dir       string
 }
 
-func getIncludePaths(commands []string) []string {
+func (c *MSVC) Arguments() []IArgument {
+	return utils.Map[GCCArgument, IArgument](c.arguments, func(arg GCCArgument) IArgument {
+		return &arg
+	})
+}
 
-	const (
-		StateParsing = iota
-		StateInclude
-	)
+func (c *MSVC) SetWorkingDirectory(dir string) {
+	c.dir = dir
+}
 
-	state := StateParsing
+func (c *MSVC) Parse(args []string) error {
+	return c.parse(args, newOptionTable(msvcOptions()))
+}
 
-	ret := make([]string, 0)
+func (c *MSVC) parse(args []string, table *optionTable) error {
+	tokens := expandResponseFiles(args, c.dir, quotingWindows)
+	compile, link := tokens, []token{}
+	for i, t := range tokens {
+		if t.value == "/link" || t.value == "-link" {
+			compile, link = tokens[:i+1], tokens[i+1:]
+			break
+		}
+	}
+
+	c.arguments = parseArguments(compile, table, isMSVCOption)
+	c.arguments = append(c.arguments, parseLinkerArguments(link)...)
 
-	for _, cmd := range commands {
+	c.resolvePrecompiledHeader()
+	c.addDefaultOutputs()
+	c.addModuleFiles()
+	return nil
+}
 
-		if state == StateInclude {
-			state = StateParsing
-			ret = append(ret, cmd)
+// option returns the arguments given by any of the spellings of an option.
+func (c *MSVC) option(names ...string) []*GCCArgument {
+	found := make([]*GCCArgument, 0)
+	for i := range c.arguments {
+		command := c.arguments[i].command
+		if command == "" || c.arguments[i].implicit {
 			continue
 		}
+		if utils.Contains(names, strings.TrimSuffix(command[1:], ":")) {
+			found = append(found, &c.arguments[i])
+		}
+	}
+	return found
+}
 
-		if cmd == "-I" || cmd == "/I" {
-			state = StateInclude
-			continue
+func (c *MSVC) hasFlag(name string) bool {
+	return len(c.option(name)) > 0
+}
+
+// resolvePrecompiledHeader makes /Fp an output when the PCH is created by /Yc.
+// Without /Fp, the PCH is named after the header, or after the source for /Yc
+// without a header.
+func (c *MSVC) resolvePrecompiledHeader() {
+	if len(c.option("Fp")) > 0 {
+		if c.hasFlag("Yc") {
+			for _, fp := range c.option("Fp") {
+				fp.argType = ArgumentTypeOutput
+			}
 		}
-		if strings.HasPrefix(cmd, "-I") || strings.HasPrefix(cmd, "/I") {
-			ret = append(ret, cmd[2:])
-			continue
+		return
+	}
+
+	if yc := c.option("Yc"); len(yc) > 0 {
+		name := yc[0].parameter
+		if sources, _ := c.sources(); name == "" && len(sources) > 0 {
+			name = sources[0]
 		}
-		if strings.HasPrefix(cmd, "-external:I") || strings.HasPrefix(cmd, "/external:I") {
-			ret = append(ret, cmd[11:])
-			continue
+		if name != "" {
+			c.arguments = append(c.arguments, GCCArgument{command: "/Fp", argType: ArgumentTypeOutput, parameter: replaceExtension(name, ".pch"), implicit: true})
 		}
+		return
 	}
 
-	return ret
+	if yu := c.option("Yu"); len(yu) > 0 && yu[0].parameter != "" {
+		pch := replaceExtension(yu[0].parameter, ".pch")
+		if _, err := os.Stat(filepath.Join(c.dir, pch)); err == nil {
+			c.arguments = append(c.arguments, GCCArgument{command: "/Fp", argType: ArgumentTypeInput, parameter: pch, implicit: true})
+		}
+	}
 }
 
-func getInputFiles(commands []string) []string {
-	ret := make([]string, 0)
-	for _, cmd := range commands {
-		if !strings.HasPrefix(cmd, "-") && !strings.HasPrefix(cmd, "/") {
-			ret = append(ret, cmd)
+// sources returns the compiled files with their languages, given by /TP, /TC,
+// /Tp, /Tc or by their extensions. Objects and libraries are not sources.
+func (c *MSVC) sources() ([]string, []string) {
+	sources, languages := make([]string, 0), make([]string, 0)
+	language := ""
+	if c.hasFlag("TP") {
+		language = "c++"
+	} else if c.hasFlag("TC") {
+		language = "c"
+	}
+
+	linking := false
+	for _, arg := range c.arguments {
+		switch {
+		case arg.command == "/link" || arg.command == "-link":
+			linking = true
+		case linking:
+		case arg.command == "" && isMSVCSource(arg.parameter):
+			l := language
+			if l == "" {
+				l, _ = sourceLanguage(strings.ToLower(arg.parameter))
+			}
+			sources, languages = append(sources, arg.Parameter()), append(languages, l)
+		case len(arg.command) == 3 && arg.command[1:] == "Tp":
+			sources, languages = append(sources, arg.Parameter()), append(languages, "c++")
+		case len(arg.command) == 3 && arg.command[1:] == "Tc":
+			sources, languages = append(sources, arg.Parameter()), append(languages, "c")
+		case arg.command != "" && strings.HasPrefix(arg.command[1:], "headerName:"):
+			// the header of a header unit
+			sources, languages = append(sources, arg.Parameter()), append(languages, "c++-header")
+		case arg.command == "" && c.hasFlag("exportHeader"):
+			sources, languages = append(sources, arg.Parameter()), append(languages, "c++-header")
 		}
 	}
-	return ret
+	return sources, languages
 }
 
-func getOutputFiles(commands []string) []string {
-	ret := make([]string, 0)
-	for _, cmd := range commands {
-		if strings.HasPrefix(cmd, "-Fo") || strings.HasPrefix(cmd, "/Fo") {
-			ret = append(ret, cmd[3:])
-		}
+func (c *MSVC) mode(sources []string) string {
+	switch {
+	case len(sources) == 0 && !utils.ContainsIf(c.arguments, func(arg GCCArgument) bool { return arg.command == "" }):
+		return ModeQuery
+	case c.hasFlag("E") || c.hasFlag("EP") || c.hasFlag("P"):
+		return ModePreprocess
+	case c.hasFlag("Zs"):
+		return ModeSyntax
+	case c.hasFlag("c"):
+		return ModeCompile
 	}
+	return ModeLink
+}
 
-	if len(ret) == 0 {
-		suffix := ".exe"
-		if utils.Contains(commands, "-c") || utils.Contains(commands, "/c") {
-			suffix = ".obj"
+func (c *MSVC) Invocation() Invocation {
+	sources, languages := c.sources()
+	invocation := Invocation{Mode: c.mode(sources), Sources: sources}
+	if len(languages) > 0 {
+		invocation.Language = languages[0]
+	}
+
+	primary := map[string][]string{
+		ModeCompile:    {"Fo"},
+		ModePreprocess: {"Fi"},
+		ModeLink:       {"Fe", "OUT"},
+	}[invocation.Mode]
+	for _, arg := range c.arguments {
+		command := strings.TrimSuffix(strings.TrimLeft(linkerCommand(arg.command), "/"), ":")
+		if arg.IsOutput() && invocation.Output == "" && utils.ContainsIf(primary, func(name string) bool { return strings.ToUpper(name) == command }) {
+			invocation.Output = arg.Parameter()
 		}
-		inputNames := getInputFiles(commands)
-		ret = utils.Map(inputNames, func(name string) string {
-			return strings.TrimSuffix(name, filepath.Ext(name)) + suffix
-		})
 	}
+	invocation.SideOutputs = sideOutputs(c.arguments, invocation)
 
-	return ret
+	invocation.Distributable = distributable(invocation)
+	return invocation
 }
 
-func (c *MSVC) Parse(args []string) error {
-	c.inputs = getIncludePaths(args)
-	c.inputs = append(c.inputs, getInputFiles(args)...)
+func isDirectory(path string) bool {
+	return strings.HasSuffix(path, "/") || strings.HasSuffix(path, "\\")
+}
 
-	c.outputs = getOutputFiles(args)
-	return nil
+// addDefaultOutputs adds the outputs cl.exe names on its own: objects are named
+// after the sources in the working directory, or in the directory given by /Fo.
+func (c *MSVC) addDefaultOutputs() {
+	sources, _ := c.sources()
+	mode := c.mode(sources)
+
+	implicit := func(command string, path string) {
+		c.arguments = append(c.arguments, GCCArgument{command: command, argType: ArgumentTypeOutput, parameter: path, implicit: true})
+	}
+
+	switch mode {
+	case ModePreprocess:
+		if c.hasFlag("P") && len(c.option("Fi")) == 0 {
+			for _, source := range sources {
+				implicit("/Fi", replaceExtension(source, ".i"))
+			}
+		}
+		return
+	case ModeCompile, ModeLink:
+	default:
+		return
+	}
+
+	// like /Fo, a directory is kept on the command line and the files written
+	// into it are added as implicit outputs
+	for _, fd := range c.option("Fd") {
+		if isDirectory(fd.parameter) {
+			fd.argType = ArgumentTypeValue
+			if c.hasFlag("Zi") || c.hasFlag("ZI") {
+				// the PDB of the compiler is named after its toolset
+				implicit("/Fd", fd.parameter+"vc140.pdb")
+			}
+		}
+	}
+	listing := ".asm"
+	if len(c.option("FAc", "FAcs", "FAsc")) > 0 {
+		listing = ".cod"
+	}
+	for _, named := range []struct{ name, extension string }{{"Fa", listing}, {"FR", ".sbr"}, {"Fr", ".sbr"}, {"sourceDependencies", ".json"}} {
+		for _, arg := range c.option(named.name) {
+			if !isDirectory(arg.parameter) {
+				continue
+			}
+			arg.argType = ArgumentTypeValue
+			extension := named.extension
+			for _, source := range sources {
+				if named.name == "sourceDependencies" {
+					// the source name is kept, a.cpp.json
+					implicit(arg.command, arg.parameter+filepath.Base(strings.ReplaceAll(source, "\\", "/"))+extension)
+				} else {
+					implicit(arg.command, arg.parameter+replaceExtension(source, extension))
+				}
+			}
+		}
+	}
+
+	objectDir := ""
+	fo := c.option("Fo")
+	if len(fo) > 0 {
+		if !isDirectory(fo[0].parameter) {
+			if mode == ModeCompile {
+				return
+			}
+		} else {
+			objectDir = fo[0].parameter
+			// the directory is kept on the command line, the objects are added as implicit outputs
+			fo[0].argType = ArgumentTypeValue
+		}
+	}
+
+	for _, source := range sources {
+		if len(fo) > 0 && !isDirectory(fo[0].parameter) || c.hasFlag("ifcOnly") {
+			break
+		}
+		implicit("/Fo", objectDir+replaceExtension(source, ".obj"))
+	}
+
+	if mode == ModeCompile {
+		return
+	}
+
+	if utils.ContainsIf(c.arguments, func(arg GCCArgument) bool {
+		return arg.IsOutput() && linkerCommand(arg.command) == "/OUT:"
+	}) {
+		return
+	}
+
+	// the executable is named after the first source or object
+	first, ok := utils.Find(c.arguments, func(arg GCCArgument) bool { return arg.command == "" })
+	if len(sources) > 0 || !ok {
+		first = GCCArgument{parameter: sources[0]}
+	}
+
+	fe := c.option("Fe")
+	switch {
+	case len(fe) == 0:
+		implicit("/Fe", replaceExtension(first.parameter, ".exe"))
+	case isDirectory(fe[0].parameter):
+		fe[0].argType = ArgumentTypeValue
+		implicit("/Fe", fe[0].parameter+replaceExtension(first.parameter, ".exe"))
+	}
 }
 
-func (c *MSVC) GetInputs() []string {
-	return c.inputs
+func (c *MSVC) WriteResponseFiles() error {
+	return writeResponseFiles(c.arguments, quotingWindows)
 }
 
-func (c *MSVC) GetOutputs() []string {
-	return c.outputs
+func (c *MSVC) Chroot(path string) {
+	for i, arg := range c.arguments {
+		arg.Chroot(path)
+		c.arguments[i] = arg
+	}
 }
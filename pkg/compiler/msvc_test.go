@@ -0,0 +1,74 @@
+package compiler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var msvcCorpus = []corpusCase{
+	{"compile default object", []string{"/c", "a.cpp"}, []string{"a.cpp"}, []string{"a.obj"}},
+	{"compile dash", []string{"-c", "-nologo", "a.cpp"}, []string{"a.cpp"}, []string{"a.obj"}},
+	{"object joined", []string{"/c", "a.cpp", "/Foout/a.obj"}, []string{"a.cpp"}, []string{"out/a.obj"}},
+	{"object colon", []string{"/c", "a.cpp", "/Fo:out/a.obj"}, []string{"a.cpp"}, []string{"out/a.obj"}},
+	{"object colon separate", []string{"/c", "a.cpp", "/Fo:", "out/a.obj"}, []string{"a.cpp"}, []string{"out/a.obj"}},
+	{"object directory", []string{"/c", "a.cpp", "src\\b.c", "/Foout\\"}, []string{"a.cpp", "src\\b.c"}, []string{"out\\a.obj", "out\\b.obj"}},
+	{"multiple sources", []string{"/c", "a.cpp", "b.cpp"}, []string{"a.cpp", "b.cpp"}, []string{"a.obj", "b.obj"}},
+	{"include joined", []string{"/c", "/Iinc", "a.cpp"}, []string{"inc", "a.cpp"}, []string{"a.obj"}},
+	{"include separate", []string{"/c", "/I", "inc", "-I", "inc2", "a.cpp"}, []string{"inc", "inc2", "a.cpp"}, []string{"a.obj"}},
+	{"external include", []string{"/c", "/external:I", "ext", "/external:W0", "a.cpp"}, []string{"ext", "a.cpp"}, []string{"a.obj"}},
+	{"forced include", []string{"/c", "/FIpch.h", "/FI", "other.h", "a.cpp"}, []string{"pch.h", "other.h", "a.cpp"}, []string{"a.obj"}},
+	{"using", []string{"/c", "/AIrefs", "/FUlib.dll", "a.cpp"}, []string{"refs", "lib.dll", "a.cpp"}, []string{"a.obj"}},
+	{"defines", []string{"/c", "/DFOO", "/D", "BAR=1", "/UBAZ", "a.cpp"}, []string{"a.cpp"}, []string{"a.obj"}},
+	{"explicit language", []string{"/c", "/Tpa.inl", "/Tc", "b.x"}, []string{"a.inl", "b.x"}, []string{"a.obj", "b.obj"}},
+	{"language flags", []string{"/c", "/TP", "/EHsc", "/std:c++20", "a.c"}, []string{"a.c"}, []string{"a.obj"}},
+	{"pdb", []string{"/c", "/Zi", "/Fdout/vc.pdb", "a.cpp"}, []string{"a.cpp"}, []string{"out/vc.pdb", "a.obj"}},
+	{"pdb directory", []string{"/nologo", "/c", "/Zi", "/FdCMakeFiles/foo.dir/", "/FoCMakeFiles/foo.dir/a.cpp.obj", "a.cpp"}, []string{"a.cpp"}, []string{"CMakeFiles/foo.dir/a.cpp.obj", "CMakeFiles/foo.dir/vc140.pdb"}},
+	{"pdb directory without debug info", []string{"/c", "/Fd:out\\", "a.cpp"}, []string{"a.cpp"}, []string{"a.obj"}},
+	{"listing directory", []string{"/c", "/FAc", "/Falist\\", "/FRbrowse\\", "a.cpp", "src\\b.cpp"}, []string{"a.cpp", "src\\b.cpp"}, []string{"list\\a.cod", "list\\b.cod", "browse\\a.sbr", "browse\\b.sbr", "a.obj", "b.obj"}},
+	{"source dependencies directory", []string{"/c", "/sourceDependencies", "deps/", "a.cpp"}, []string{"a.cpp"}, []string{"deps/a.cpp.json", "a.obj"}},
+	{"listings", []string{"/c", "/FAcs", "/Faa.asm", "/FC", "a.cpp"}, []string{"a.cpp"}, []string{"a.asm", "a.obj"}},
+	{"preprocess to file", []string{"/P", "/Fia.i", "a.cpp"}, []string{"a.cpp"}, []string{"a.i"}},
+	{"preprocess default", []string{"/P", "/C", "a.cpp"}, []string{"a.cpp"}, []string{"a.i"}},
+	{"preprocess to stdout", []string{"/E", "a.cpp"}, []string{"a.cpp"}, []string{}},
+	{"pch create", []string{"/c", "/Ycpch.h", "/Fppch.pch", "pch.cpp"}, []string{"pch.cpp"}, []string{"pch.pch", "pch.obj"}},
+	{"pch use", []string{"/c", "/Yupch.h", "/Fppch.pch", "a.cpp"}, []string{"pch.pch", "a.cpp"}, []string{"a.obj"}},
+	{"pch create default", []string{"/c", "/Ycstdafx.h", "stdafx.cpp"}, []string{"stdafx.cpp"}, []string{"stdafx.pch", "stdafx.obj"}},
+	{"pch create without header", []string{"/c", "/Yc", "pch.cpp"}, []string{"pch.cpp"}, []string{"pch.pch", "pch.obj"}},
+	{"source dependencies", []string{"/c", "/sourceDependencies", "a.json", "a.cpp"}, []string{"a.cpp"}, []string{"a.json", "a.obj"}},
+	{"link default", []string{"a.cpp"}, []string{"a.cpp"}, []string{"a.obj", "a.exe"}},
+	{"link named", []string{"a.cpp", "/Feapp.exe"}, []string{"a.cpp"}, []string{"app.exe", "a.obj"}},
+	{"link directory", []string{"a.cpp", "/Fe:bin\\"}, []string{"a.cpp"}, []string{"a.obj", "bin\\a.exe"}},
+	{"linker options", []string{"a.cpp", "/link", "/out:app.exe", "/LIBPATH:lib", "/pdb:app.pdb", "kernel32.lib", "/DEBUG"}, []string{"a.cpp", "lib", "kernel32.lib"}, []string{"app.exe", "app.pdb", "a.obj"}},
+	{"linker sources are not compiled", []string{"a.cpp", "-link", "b.obj", "-OUT:app.exe"}, []string{"a.cpp", "b.obj"}, []string{"app.exe", "a.obj"}},
+	{"absolute path", []string{"/c", "/home/user/a.cpp", "/Fo/home/user/a.obj"}, []string{"/home/user/a.cpp"}, []string{"/home/user/a.obj"}},
+	{"response file", []string{"/c", "@args.rsp", "a.cpp"}, []string{"@args.rsp", "a.cpp"}, []string{"a.obj"}},
+	{"trailing option", []string{"/c", "a.cpp", "/I"}, []string{"a.cpp"}, []string{"a.obj"}},
+}
+
+func TestMSVCCorpus(t *testing.T) {
+	runCorpus(t, MSVCCompiler, msvcCorpus)
+}
+
+func TestMSVCPrecompiledHeaderDefault(t *testing.T) {
+	writeTree(t, map[string]string{"stdafx.pch": ""})
+
+	msvc := NewCompiler(MSVCCompiler)
+	msvc.Parse([]string{"/c", "/Yustdafx.h", "a.cpp"})
+	expected := []string{"a.cpp", "stdafx.pch"}
+	if inputs := GetInputs(msvc); !reflect.DeepEqual(inputs, expected) {
+		t.Errorf("Expected inputs ['%s'], got ['%s']", strings.Join(expected, "', '"), strings.Join(inputs, "', '"))
+	}
+}
+
+func TestMSVCChrootKeepsValues(t *testing.T) {
+	msvc := NewCompiler(MSVCCompiler)
+	msvc.Parse([]string{"/c", "/DFOO=/usr", "/I", "inc", "/Yupch.h", "/Fppch.pch", "a.cpp", "/Fo:a.obj", "/link", "/OUT:app.exe", "/DEBUG"})
+	msvc.Chroot("/tmp/root")
+
+	expected := []string{"/c", "/DFOO=/usr", "/I", "/tmp/root/inc", "/Yupch.h", "/Fp/tmp/root/pch.pch", "/tmp/root/a.cpp", "/Fo:/tmp/root/a.obj", "/link", "/OUT:/tmp/root/app.exe", "/DEBUG"}
+	command := GetCommand(msvc)
+	if !reflect.DeepEqual(command, expected) {
+		t.Errorf("Expected command ['%s'], got ['%s']", strings.Join(expected, "', '"), strings.Join(command, "', '"))
+	}
+}
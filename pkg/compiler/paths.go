@@ -0,0 +1,243 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// PathMap maps the paths of a chroot, e.g. the workspace of an executor, back
+// to the paths the client passed. The chroot does not tell absolute paths from
+// relative ones, so the absolute paths of the client must be known.
+type PathMap struct {
+	root     string
+	absolute map[string]bool // the absolute paths of the client and their directories, without the leading slash
+}
+
+// NewPathMap creates the map of a chroot from the client paths of its files.
+func NewPathMap(root string, paths []string) *PathMap {
+	m := &PathMap{root: filepath.Clean(root), absolute: make(map[string]bool)}
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			continue
+		}
+		for dir := filepath.Clean(path); dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+			m.absolute[dir[1:]] = true
+		}
+	}
+	return m
+}
+
+// Path returns the client path of a path in the chroot. Paths outside of the
+// chroot are returned as they are.
+func (m *PathMap) Path(path string) string {
+	return m.replace(path, func(rest string) int { return len(rest) }, func(path string) string { return path })
+}
+
+// isAbsolute tells whether the path following the chroot was an absolute
+// client path, or is in the directory of one. The end of a path in a text is
+// not always known, so it is shortened at the spaces until a client path is found.
+func (m *PathMap) isAbsolute(rest string) bool {
+	rest = strings.TrimPrefix(rest, "/")
+	for {
+		if path := filepath.Clean(rest); m.absolute[path] || m.absolute[filepath.Dir(path)] {
+			return true
+		}
+		i := strings.LastIndexAny(rest, " \t")
+		if i < 0 {
+			return false
+		}
+		rest = rest[:i]
+	}
+}
+
+// replace rewrites every occurrence of the chroot in text. The end of a path is
+// found by the end function, which gets the text following the chroot. Only
+// the chroot is removed, the rest of the path is kept as written.
+func (m *PathMap) replace(text string, end func(rest string) int, unescape func(path string) string) string {
+	var result strings.Builder
+	for {
+		i := strings.Index(text, m.root)
+		if i < 0 {
+			result.WriteString(text)
+			return result.String()
+		}
+		result.WriteString(text[:i])
+		text = text[i+len(m.root):]
+
+		if text != "" && text[0] != '/' {
+			if isIdentifierChar(text[0]) || text[0] == '-' || text[0] == '.' {
+				// only a prefix of another name, e.g. /tmp/all-build-1 of /tmp/all-build-12
+				result.WriteString(m.root)
+			} else {
+				result.WriteString(".")
+			}
+			continue
+		}
+
+		n := end(text)
+		switch {
+		case n == 0 || text[:n] == "/":
+			result.WriteString(".")
+			if n == 1 {
+				result.WriteString("/")
+			}
+		case m.isAbsolute(unescape(text[:n])):
+			result.WriteString(text[:n])
+		default:
+			result.WriteString(text[1:n])
+		}
+		text = text[n:]
+	}
+}
+
+// RewriteDepfile maps the paths of a Make depfile back to the client paths,
+// keeping their escaping (\ , \#, $$) as the compiler wrote it.
+func (m *PathMap) RewriteDepfile(content []byte) []byte {
+	end := func(rest string) int {
+		for j := 0; j < len(rest); j++ {
+			switch c := rest[j]; {
+			case c == '\\' && j+1 < len(rest) && rest[j+1] != '\n' && rest[j+1] != '\r':
+				j++
+			case c == '$' && j+1 < len(rest) && rest[j+1] == '$':
+				j++
+			case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+				return j
+			case c == ':' && (j+1 == len(rest) || strings.IndexByte(" \t\r\n", rest[j+1]) >= 0):
+				return j
+			}
+		}
+		return len(rest)
+	}
+	return []byte(m.replace(string(content), end, unescapeMake))
+}
+
+func unescapeMake(path string) string {
+	var result strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch {
+		case path[i] == '\\' && i+1 < len(path) && (path[i+1] == ' ' || path[i+1] == '#' || path[i+1] == '\\'):
+			i++
+		case path[i] == '$' && i+1 < len(path) && path[i+1] == '$':
+			i++
+		}
+		result.WriteByte(path[i])
+	}
+	return result.String()
+}
+
+// RewriteText maps the paths in the diagnostics of a compiler back to the
+// client paths. A path ends at a colon (file:line:column), a comma (the
+// "In file included from" chains), a quote or a bracket. The
+// diagnostics in JSON or SARIF are rewritten value by value.
+func (m *PathMap) RewriteText(text string) string {
+	if rewritten, ok := m.RewriteJSON([]byte(text)); ok {
+		return string(rewritten)
+	}
+
+	end := func(rest string) int {
+		for j := 0; j < len(rest); j++ {
+			switch {
+			case strings.IndexByte("\n\r:,\"'`()[]<>", rest[j]) >= 0:
+				return j
+			case strings.HasPrefix(rest[j:], "‘") || strings.HasPrefix(rest[j:], "’"):
+				// the typographic quotes of GCC
+				return j
+			}
+		}
+		return len(rest)
+	}
+	return m.replace(text, end, func(path string) string { return path })
+}
+
+// RewriteJSON maps the paths in the string values of a JSON document, e.g. the
+// diagnostics of -fdiagnostics-format=json or sarif. The order of the members
+// is kept. It fails when the content is not a JSON document.
+func (m *PathMap) RewriteJSON(content []byte) ([]byte, bool) {
+	trimmed := bytes.TrimSpace(content)
+	if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') || !json.Valid(trimmed) {
+		return nil, false
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.UseNumber()
+	var result bytes.Buffer
+	if err := m.rewriteJSONValue(decoder, &result); err != nil {
+		return nil, false
+	}
+	if bytes.HasSuffix(content, []byte("\n")) {
+		result.WriteByte('\n')
+	}
+	return result.Bytes(), true
+}
+
+func (m *PathMap) rewriteJSONValue(decoder *json.Decoder, result *bytes.Buffer) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	switch value := token.(type) {
+	case json.Delim:
+		closing := json.Delim('}')
+		if value == '[' {
+			closing = ']'
+		}
+		result.WriteRune(rune(value))
+		for i := 0; decoder.More(); i++ {
+			if i > 0 {
+				result.WriteByte(',')
+			}
+			if value == '{' {
+				key, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+				writeJSONString(result, key.(string))
+				result.WriteByte(':')
+			}
+			if err := m.rewriteJSONValue(decoder, result); err != nil {
+				return err
+			}
+		}
+		if _, err := decoder.Token(); err != nil {
+			return err
+		}
+		result.WriteRune(rune(closing))
+	case string:
+		writeJSONString(result, m.rewriteJSONString(value))
+	case json.Number:
+		result.WriteString(value.String())
+	case bool:
+		result.WriteString(strconv.FormatBool(value))
+	case nil:
+		result.WriteString("null")
+	}
+	return nil
+}
+
+// rewriteJSONString maps a path, a file URI (SARIF) or the paths in a message.
+func (m *PathMap) rewriteJSONString(value string) string {
+	switch {
+	case strings.HasPrefix(value, m.root):
+		return m.Path(value)
+	case strings.HasPrefix(value, "file://"+m.root):
+		path := m.Path(value[len("file://"):])
+		if strings.HasPrefix(path, "/") {
+			return "file://" + path
+		}
+		// a relative reference to the client working directory
+		return path
+	}
+	return m.RewriteText(value)
+}
+
+func writeJSONString(result *bytes.Buffer, value string) {
+	encoder := json.NewEncoder(result)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+	// the encoder ends every value with a new line
+	result.Truncate(result.Len() - 1)
+}
@@ -0,0 +1,86 @@
+package compiler
+
+import (
+	"testing"
+)
+
+func TestPathMap(t *testing.T) {
+	m := NewPathMap("/tmp/ws-1", []string{"src/a.c", "/opt/sdk/include/sdk.h", "out/a.o"})
+
+	cases := map[string]string{
+		"/tmp/ws-1/src/a.c":               "src/a.c",
+		"/tmp/ws-1/opt/sdk/include/sdk.h": "/opt/sdk/include/sdk.h",
+		"/tmp/ws-1/opt/sdk/include":       "/opt/sdk/include",
+		"/tmp/ws-1/inc/new.h":             "inc/new.h",
+		"/tmp/ws-1":                       ".",
+		"/usr/include/stdio.h":            "/usr/include/stdio.h",
+		"/tmp/ws-12/src/a.c":              "/tmp/ws-12/src/a.c",
+	}
+	for path, expected := range cases {
+		if mapped := m.Path(path); mapped != expected {
+			t.Errorf("Expected %s to map to %s, got %s", path, expected, mapped)
+		}
+	}
+}
+
+func TestRewriteDepfile(t *testing.T) {
+	m := NewPathMap("/tmp/ws-1", []string{"src/my file.c", "/opt/sdk/include/sdk.h"})
+
+	depfile := "/tmp/ws-1/out/a.o: /tmp/ws-1/src/my\\ file.c \\\n" +
+		" /tmp/ws-1/opt/sdk/include/sdk.h /tmp/ws-1/inc/hash\\#1.h \\\n" +
+		" /tmp/ws-1/inc/cost$$.h /usr/include/stdio.h /tmp/ws-12/other.h\n" +
+		"/tmp/ws-1/inc/cost$$.h:\n"
+	expected := "out/a.o: src/my\\ file.c \\\n" +
+		" /opt/sdk/include/sdk.h inc/hash\\#1.h \\\n" +
+		" inc/cost$$.h /usr/include/stdio.h /tmp/ws-12/other.h\n" +
+		"inc/cost$$.h:\n"
+
+	if rewritten := string(m.RewriteDepfile([]byte(depfile))); rewritten != expected {
+		t.Errorf("Expected depfile\n%s\ngot\n%s", expected, rewritten)
+	}
+}
+
+func TestRewriteText(t *testing.T) {
+	m := NewPathMap("/tmp/ws-1", []string{"src/a.c", "/opt/my sdk/sdk.h"})
+
+	stderr := "In file included from /tmp/ws-1/opt/my sdk/sdk.h:3,\n" +
+		"                 from /tmp/ws-1/src/a.c:1:\n" +
+		"/tmp/ws-1/inc/b.h:2:10: fatal error: ‘/tmp/ws-1/opt/my sdk/missing.h’ file not found\n" +
+		"/tmp/ws-1/src/a.c(12,3): warning: \"/tmp/ws-1/inc/my header.h\" is deprecated\n" +
+		"cc1: note: search starts in /tmp/ws-1 and /tmp/ws-12/inc\n"
+	expected := "In file included from /opt/my sdk/sdk.h:3,\n" +
+		"                 from src/a.c:1:\n" +
+		"inc/b.h:2:10: fatal error: ‘/opt/my sdk/missing.h’ file not found\n" +
+		"src/a.c(12,3): warning: \"inc/my header.h\" is deprecated\n" +
+		"cc1: note: search starts in . and /tmp/ws-12/inc\n"
+
+	if rewritten := m.RewriteText(stderr); rewritten != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, rewritten)
+	}
+}
+
+func TestRewriteJSON(t *testing.T) {
+	m := NewPathMap("/tmp/ws-1", []string{"src/a.c", "/opt/sdk/sdk.h"})
+
+	diagnostics := `[{"kind":"error","locations":[{"caret":{"file":"/tmp/ws-1/src/a.c","line":3,"column":1.5}}],` +
+		`"message":"'/tmp/ws-1/opt/sdk/sdk.h' <b>","children":[],"fixed":true,"option":null}]` + "\n"
+	expected := `[{"kind":"error","locations":[{"caret":{"file":"src/a.c","line":3,"column":1.5}}],` +
+		`"message":"'/opt/sdk/sdk.h' <b>","children":[],"fixed":true,"option":null}]` + "\n"
+
+	if rewritten := m.RewriteText(diagnostics); rewritten != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, rewritten)
+	}
+
+	sarif := `{"runs":[{"originalUriBaseIds":{"PWD":{"uri":"file:///tmp/ws-1/"}},` +
+		`"artifacts":[{"location":{"uri":"file:///tmp/ws-1/opt/sdk/sdk.h"}},{"location":{"uri":"src/a.c","uriBaseId":"PWD"}}]}]}`
+	expected = `{"runs":[{"originalUriBaseIds":{"PWD":{"uri":"./"}},` +
+		`"artifacts":[{"location":{"uri":"file:///opt/sdk/sdk.h"}},{"location":{"uri":"src/a.c","uriBaseId":"PWD"}}]}]}`
+
+	if rewritten, ok := m.RewriteJSON([]byte(sarif)); !ok || string(rewritten) != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, rewritten)
+	}
+
+	if _, ok := m.RewriteJSON([]byte("[not json")); ok {
+		t.Errorf("Expected invalid JSON not to be rewritten")
+	}
+}
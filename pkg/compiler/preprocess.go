@@ -0,0 +1,149 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Zeeno-atl/all-build/internal/utils"
+)
+
+// options which only matter to the preprocessor, they are dropped when the
+// preprocessed file is compiled
+var gccPreprocessorOptions = []string{
+	"-I", "--include-directory", "-iquote", "-isystem", "-idirafter", "-isystem-after", "--include-directory-after", "-I-",
+	"-include", "--include", "-imacros", "--imacros", "-iprefix", "-iwithprefix", "-iwithprefixbefore",
+	"-imultilib", "-isysroot", "-nostdinc", "-nostdinc++", "-undef",
+	"-D", "--define-macro", "-U", "--undefine-macro", "-A", "--assert",
+	"-M", "-MM", "-MD", "-MMD", "-MF", "-MT", "-MQ", "-MP", "-MG",
+	"-Xpreprocessor", "-x", "--language",
+	"-ivfsoverlay", "-imsvc",
+}
+
+var msvcPreprocessorOptions = []string{
+	"I", "external:I", "external:env:", "FI", "D", "U", "X", "u", "TP", "TC", "Tp", "Tc",
+	"imsvc", "winsysroot", "winsdkdir", "vctoolsdir", "winsdkversion", "vctoolsversion",
+}
+
+// preprocessedLanguages are the languages of the preprocessed sources
+var preprocessedLanguages = map[string]string{
+	"c":                  "cpp-output",
+	"c++":                "c++-cpp-output",
+	"objective-c":        "objective-c-cpp-output",
+	"objective-c++":      "objective-c++-cpp-output",
+	"assembler-with-cpp": "assembler",
+}
+
+// expandedTokens returns the arguments with the response files expanded.
+func expandedTokens(arguments []GCCArgument, skip func(arg GCCArgument) bool) []string {
+	tokens := make([]string, 0)
+	for _, arg := range arguments {
+		if arg.command != responseCommand && !skip(arg) {
+			tokens = append(tokens, arg.Tokens()...)
+		}
+	}
+	return tokens
+}
+
+// Preprocess splits a distributable invocation into the command preprocessing
+// the source into the output and the command compiling the preprocessed file.
+// With directivesOnly, only the directives are processed (-fdirectives-only),
+// so the macros stay in the code and the diagnostics are not changed.
+func (c *GCC) Preprocess(output string, directivesOnly bool) ([]string, []string, error) {
+	return c.preprocess(output, directivesOnly, []string{"-fdirectives-only"}, []string{"-fpreprocessed", "-fdirectives-only"})
+}
+
+func (c *GCC) preprocess(output string, directivesOnly bool, localFlags []string, remoteFlags []string) ([]string, []string, error) {
+	invocation := c.Invocation()
+	if !invocation.Distributable || invocation.Mode == ModePreprocess {
+		return nil, nil, fmt.Errorf("only a compilation of a single source can be preprocessed")
+	}
+	if usesModules(c.arguments, invocation) || c.hasFlag("-fmodules-ts", "-fmodules", "--precompile", "-fmodule-output") || c.hasFlagPrefix("-fmodule-file=") {
+		return nil, nil, fmt.Errorf("module units cannot be preprocessed")
+	}
+
+	language := invocation.Language
+	if !directivesOnly {
+		preprocessed, ok := preprocessedLanguages[language]
+		if !ok {
+			return nil, nil, fmt.Errorf("%s cannot be preprocessed", language)
+		}
+		language = preprocessed
+	}
+
+	isOutput := func(arg GCCArgument) bool {
+		return utils.Contains([]string{"-c", "-S", "-fsyntax-only", "-o", "--output", "--output="}, arg.command)
+	}
+	local := expandedTokens(c.arguments, isOutput)
+	local = append(local, "-E", "-o", output)
+	if directivesOnly {
+		local = append(local, localFlags...)
+	}
+	if invocation.Depfile != "" {
+		// the depfile is written by the preprocessor, it must not be named after the preprocessed file
+		if !c.hasFlag("-MF") {
+			local = append(local, "-MF", invocation.Depfile)
+		}
+		if !c.hasFlag("-MT", "-MQ") && invocation.Output != "" {
+			local = append(local, "-MT", invocation.Output)
+		}
+	}
+
+	remote := expandedTokens(c.arguments, func(arg GCCArgument) bool {
+		command := strings.TrimSuffix(arg.command, "=")
+		return utils.Contains(gccPreprocessorOptions, command) || utils.Contains(invocation.Sources, arg.Parameter()) && arg.command == ""
+	})
+	if !c.hasFlag("-o", "--output", "--output=") && invocation.Output != "" {
+		// the default output would be named after the preprocessed file
+		remote = append(remote, "-o", invocation.Output)
+	}
+	if directivesOnly {
+		remote = append(remote, remoteFlags...)
+	}
+	remote = append(remote, "-x", language, output)
+
+	return local, remote, nil
+}
+
+// Preprocess of clang rewrites only the includes (-frewrite-includes) for directivesOnly.
+func (c *Clang) Preprocess(output string, directivesOnly bool) ([]string, []string, error) {
+	return c.preprocess(output, directivesOnly, []string{"-frewrite-includes"}, []string{})
+}
+
+// Preprocess of MSVC runs /P, cl.exe has no equivalent of -fdirectives-only.
+func (c *MSVC) Preprocess(output string, directivesOnly bool) ([]string, []string, error) {
+	invocation := c.Invocation()
+	if !invocation.Distributable || invocation.Mode == ModePreprocess {
+		return nil, nil, fmt.Errorf("only a compilation of a single source can be preprocessed")
+	}
+	if directivesOnly {
+		return nil, nil, fmt.Errorf("msvc cannot preprocess only the directives")
+	}
+	if usesModules(c.arguments, invocation) || len(c.option("interface", "internalPartition", "exportHeader", "reference", "headerUnit", "headerUnit:quote", "headerUnit:angle")) > 0 {
+		return nil, nil, fmt.Errorf("module units cannot be preprocessed")
+	}
+
+	local := expandedTokens(c.arguments, func(arg GCCArgument) bool {
+		return arg.IsOutput() || (arg.command != "" && utils.Contains([]string{"c", "Zs"}, arg.command[1:]))
+	})
+	local = append(local, "/P", "/Fi"+output)
+
+	remote := expandedTokens(c.arguments, func(arg GCCArgument) bool {
+		if arg.command == "" {
+			return utils.Contains(invocation.Sources, arg.Parameter())
+		}
+		// the default object would be named after the preprocessed file
+		name := strings.TrimSuffix(arg.command[1:], ":")
+		return utils.Contains(msvcPreprocessorOptions, name) || (name == "Fo" && invocation.Mode == ModeCompile)
+	})
+	if invocation.Mode == ModeCompile {
+		remote = append(remote, "/Fo"+invocation.Output)
+	}
+	switch invocation.Language {
+	case "c":
+		remote = append(remote, "/Tc"+output)
+	default:
+		remote = append(remote, "/Tp"+output)
+	}
+
+	return local, remote, nil
+}
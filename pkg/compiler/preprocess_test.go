@@ -0,0 +1,65 @@
+package compiler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPreprocess(t *testing.T) {
+	cases := []struct {
+		compiler       string
+		args           []string
+		directivesOnly bool
+		output         string
+		local          []string
+		remote         []string
+	}{
+		{GCCCompiler, []string{"-c", "-Iinc", "-DFOO=1", "-O2", "a.cpp", "-o", "a.o"}, false, "a.o.ii",
+			[]string{"-Iinc", "-DFOO=1", "-O2", "a.cpp", "-E", "-o", "a.o.ii"},
+			[]string{"-c", "-O2", "-o", "a.o", "-x", "c++-cpp-output", "a.o.ii"}},
+		{GCCCompiler, []string{"-c", "-include", "pch.h", "-MD", "-x", "c", "a.inl"}, false, "a.i",
+			[]string{"-include", "pch.h", "-MD", "-x", "c", "a.inl", "-E", "-o", "a.i", "-MF", "a.d", "-MT", "a.o"},
+			[]string{"-c", "-o", "a.o", "-x", "cpp-output", "a.i"}},
+		{GCCCompiler, []string{"-c", "-isystem", "sys", "-MMD", "-MF", "a.d", "a.c", "-o", "a.o"}, true, "a.o.i",
+			[]string{"-isystem", "sys", "-MMD", "-MF", "a.d", "a.c", "-E", "-o", "a.o.i", "-fdirectives-only", "-MT", "a.o"},
+			[]string{"-c", "-o", "a.o", "-fpreprocessed", "-fdirectives-only", "-x", "c", "a.o.i"}},
+		{ClangCompiler, []string{"-c", "--target=x86_64-linux-gnu", "-Iinc", "a.cpp", "-o", "a.o"}, true, "a.o.ii",
+			[]string{"--target=x86_64-linux-gnu", "-Iinc", "a.cpp", "-E", "-o", "a.o.ii", "-frewrite-includes"},
+			[]string{"-c", "--target=x86_64-linux-gnu", "-o", "a.o", "-x", "c++", "a.o.ii"}},
+		{MSVCCompiler, []string{"/c", "/nologo", "/Iinc", "/DFOO", "a.cpp", "/Fo:a.obj"}, false, "a.obj.ii",
+			[]string{"/nologo", "/Iinc", "/DFOO", "a.cpp", "/P", "/Fia.obj.ii"},
+			[]string{"/c", "/nologo", "/Foa.obj", "/Tpa.obj.ii"}},
+	}
+
+	for _, tc := range cases {
+		c := NewCompiler(tc.compiler)
+		c.Parse(tc.args)
+		local, remote, err := c.Preprocess(tc.output, tc.directivesOnly)
+		if err != nil {
+			t.Fatalf("Preprocess failed: %v", err)
+		}
+		if !reflect.DeepEqual(local, tc.local) {
+			t.Errorf("Expected local command ['%s'], got ['%s']", strings.Join(tc.local, "', '"), strings.Join(local, "', '"))
+		}
+		if !reflect.DeepEqual(remote, tc.remote) {
+			t.Errorf("Expected remote command ['%s'], got ['%s']", strings.Join(tc.remote, "', '"), strings.Join(remote, "', '"))
+		}
+	}
+}
+
+func TestPreprocessNotDistributable(t *testing.T) {
+	for _, args := range [][]string{{"a.o", "-o", "app"}, {"-E", "a.c"}, {"--version"}} {
+		gcc := NewCompiler(GCCCompiler)
+		gcc.Parse(args)
+		if _, _, err := gcc.Preprocess("a.i", false); err == nil {
+			t.Errorf("Expected %v not to be preprocessed", args)
+		}
+	}
+
+	msvc := NewCompiler(MSVCCompiler)
+	msvc.Parse([]string{"/c", "a.cpp"})
+	if _, _, err := msvc.Preprocess("a.ii", true); err == nil {
+		t.Errorf("Expected msvc not to preprocess only the directives")
+	}
+}
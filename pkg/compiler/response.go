@@ -0,0 +1,244 @@
+package compiler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+// quoting rules of the response files
+const (
+	quotingGNU     = iota // backslash escapes any character, single and double quotes
+	quotingWindows        // backslashes are literal unless they precede a double quote
+)
+
+// nested response files deeper than this are most likely a cycle
+const maxResponseDepth = 16
+
+// token is an argument of the expanded command line.
+type token struct {
+	value    string
+	response string // the response file the token was read from, "" for the command line
+	file     string // the response file this token expands to
+}
+
+// expandResponseFiles replaces @file arguments by their content. The @file
+// arguments are kept as tokens, so the command line can be rebuilt.
+// Response files which cannot be read are passed on as they are.
+func expandResponseFiles(args []string, dir string, quoting int) []token {
+	return expandTokens(args, dir, "", quoting, 0)
+}
+
+func expandTokens(args []string, dir string, response string, quoting int, depth int) []token {
+	tokens := make([]token, 0, len(args))
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "@") || len(arg) == 1 || depth >= maxResponseDepth {
+			tokens = append(tokens, token{value: arg, response: response})
+			continue
+		}
+
+		file := arg[1:]
+		content, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			tokens = append(tokens, token{value: arg, response: response})
+			continue
+		}
+
+		tokens = append(tokens, token{value: arg, response: response, file: file})
+		tokens = append(tokens, expandTokens(splitResponseFile(string(content), quoting), dir, file, quoting, depth+1)...)
+	}
+	return tokens
+}
+
+func splitResponseFile(content string, quoting int) []string {
+	content = decodeResponseFile(content)
+	if quoting == quotingWindows {
+		return splitWindows(content)
+	}
+	return splitGNU(content)
+}
+
+// decodeResponseFile converts the UTF-16 response files written by MSVC tools to UTF-8.
+func decodeResponseFile(content string) string {
+	if strings.HasPrefix(content, "\xef\xbb\xbf") {
+		return content[3:]
+	}
+	if !strings.HasPrefix(content, "\xff\xfe") || len(content)%2 != 0 {
+		return content
+	}
+	units := make([]uint16, 0, len(content)/2-1)
+	for i := 2; i < len(content); i += 2 {
+		units = append(units, uint16(content[i])|uint16(content[i+1])<<8)
+	}
+	return string(utf16.Decode(units))
+}
+
+func isResponseSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// splitGNU follows libiberty's buildargv.
+func splitGNU(content string) []string {
+	args := make([]string, 0)
+	var arg strings.Builder
+	inArg := false
+	var quote byte
+
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case c == '\\' && i+1 < len(content):
+			i++
+			arg.WriteByte(content[i])
+			inArg = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				arg.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case isResponseSpace(c):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args
+}
+
+// splitWindows follows the rules of CommandLineToArgvW: 2n backslashes before
+// a double quote are n backslashes and a quote toggle, 2n+1 backslashes are n
+// backslashes and a literal quote, a doubled quote inside quotes is a quote.
+func splitWindows(content string) []string {
+	args := make([]string, 0)
+	var arg strings.Builder
+	inArg := false
+	quoted := false
+
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case c == '\\':
+			backslashes := 0
+			for i < len(content) && content[i] == '\\' {
+				backslashes++
+				i++
+			}
+			if i < len(content) && content[i] == '"' {
+				arg.WriteString(strings.Repeat("\\", backslashes/2))
+				if backslashes%2 == 1 {
+					arg.WriteByte('"')
+				} else {
+					i--
+				}
+			} else {
+				arg.WriteString(strings.Repeat("\\", backslashes))
+				i--
+			}
+			inArg = true
+		case c == '"':
+			if quoted && i+1 < len(content) && content[i+1] == '"' {
+				arg.WriteByte('"')
+				i++
+			} else {
+				quoted = !quoted
+			}
+			inArg = true
+		case isResponseSpace(c) && !quoted:
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args
+}
+
+func quoteResponseArgument(arg string, quoting int) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\r\n\"'\\") {
+		return arg
+	}
+
+	if quoting == quotingGNU {
+		var quoted strings.Builder
+		for i := 0; i < len(arg); i++ {
+			if strings.IndexByte(" \t\r\n\"'\\", arg[i]) >= 0 {
+				quoted.WriteByte('\\')
+			}
+			quoted.WriteByte(arg[i])
+		}
+		if arg == "" {
+			return "''"
+		}
+		return quoted.String()
+	}
+
+	if !strings.ContainsAny(arg, " \t\r\n\"") && arg != "" {
+		// backslashes are literal
+		return arg
+	}
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	backslashes := 0
+	for i := 0; i < len(arg); i++ {
+		switch arg[i] {
+		case '\\':
+			backslashes++
+			continue
+		case '"':
+			quoted.WriteString(strings.Repeat("\\", 2*backslashes+1))
+		default:
+			quoted.WriteString(strings.Repeat("\\", backslashes))
+		}
+		backslashes = 0
+		quoted.WriteByte(arg[i])
+	}
+	quoted.WriteString(strings.Repeat("\\", 2*backslashes))
+	quoted.WriteByte('"')
+	return quoted.String()
+}
+
+// writeResponseFiles writes every expanded response file again from its
+// arguments, e.g. after they were chrooted on the executor.
+func writeResponseFiles(arguments []GCCArgument, quoting int) error {
+	for _, file := range arguments {
+		if file.command != responseCommand {
+			continue
+		}
+
+		lines := make([]string, 0)
+		for _, arg := range arguments {
+			if arg.response != file.parameter {
+				continue
+			}
+			for _, t := range arg.Tokens() {
+				lines = append(lines, quoteResponseArgument(t, quoting))
+			}
+		}
+
+		if err := os.WriteFile(file.Parameter(), []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			return fmt.Errorf("could not write response file: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package compiler
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Zeeno-atl/all-build/internal/utils"
+)
+
+func TestSplitResponseFile(t *testing.T) {
+	cases := []struct {
+		content  string
+		quoting  int
+		expected []string
+	}{
+		{"-c a.c\n-o a.o", quotingGNU, []string{"-c", "a.c", "-o", "a.o"}},
+		{`-DNAME="a b" 'c d' e\ f g\\h`, quotingGNU, []string{"-DNAME=a b", "c d", "e f", `g\h`}},
+		{`"" -DX='"q"'`, quotingGNU, []string{"", `-DX="q"`}},
+		{`/c C:\src\a.cpp "C:\Program Files\inc"`, quotingWindows, []string{"/c", `C:\src\a.cpp`, `C:\Program Files\inc`}},
+		{`"a\"b" a\\\"b a\\"b c" "a""b"`, quotingWindows, []string{`a"b`, `a\"b`, `a\b c`, `a"b`}},
+		{"\xef\xbb\xbf/c\r\na.cpp\r\n", quotingWindows, []string{"/c", "a.cpp"}},
+		{"\xff\xfe/\x00c\x00 \x00a\x00", quotingWindows, []string{"/c", "a"}},
+	}
+
+	for _, tc := range cases {
+		if args := splitResponseFile(tc.content, tc.quoting); !reflect.DeepEqual(args, tc.expected) {
+			t.Errorf("Expected '%s' to split into ['%s'], got ['%s']", tc.content, strings.Join(tc.expected, "', '"), strings.Join(args, "', '"))
+		}
+	}
+}
+
+func TestQuoteResponseArgument(t *testing.T) {
+	args := []string{"plain", "", "a b", `a"b`, `back\slash`, `trailing\`, `C:\Program Files\`, "it's", "tab\there"}
+	for _, quoting := range []int{quotingGNU, quotingWindows} {
+		quoted := make([]string, 0, len(args))
+		for _, arg := range args {
+			quoted = append(quoted, quoteResponseArgument(arg, quoting))
+		}
+		if split := splitResponseFile(strings.Join(quoted, "\n"), quoting); !reflect.DeepEqual(split, args) {
+			t.Errorf("Expected quoting %d to round trip ['%s'], got ['%s']", quoting, strings.Join(args, "', '"), strings.Join(split, "', '"))
+		}
+	}
+}
+
+func TestGCCResponseFile(t *testing.T) {
+	writeTree(t, map[string]string{
+		"args.rsp":   "-c -Iinc @nested.rsp\n-o a.o",
+		"nested.rsp": "'-DNAME=a b' a.c",
+		"a.c":        "",
+	})
+
+	gcc := NewCompiler(GCCCompiler)
+	gcc.Parse([]string{"-O2", "@args.rsp", "@missing.rsp"})
+
+	expectInputs := []string{"args.rsp", "inc", "nested.rsp", "a.c", "@missing.rsp"}
+	if inputs := GetInputs(gcc); !reflect.DeepEqual(inputs, expectInputs) {
+		t.Errorf("Expected inputs ['%s'], got ['%s']", strings.Join(expectInputs, "', '"), strings.Join(inputs, "', '"))
+	}
+	if outputs := GetOutputs(gcc); !reflect.DeepEqual(outputs, []string{"a.o"}) {
+		t.Errorf("Expected outputs ['a.o'], got ['%s']", strings.Join(outputs, "', '"))
+	}
+
+	expected := []string{"-O2", "@args.rsp", "@missing.rsp"}
+	if command := GetCommand(gcc); !reflect.DeepEqual(command, expected) {
+		t.Errorf("Expected command ['%s'], got ['%s']", strings.Join(expected, "', '"), strings.Join(command, "', '"))
+	}
+}
+
+func TestGetResponseFiles(t *testing.T) {
+	writeTree(t, map[string]string{
+		"build/args.rsp": "-Iinc a.c",
+		"msvc.rsp":       "/c a.cpp",
+	})
+
+	cases := []struct {
+		compiler string
+		args     []string
+		expected []string
+	}{
+		{GCCCompiler, []string{"-c", "@build/args.rsp", "-o", "a.o"}, []string{"build/args.rsp"}},
+		{MSVCCompiler, []string{"@msvc.rsp", "/Foa.obj"}, []string{"msvc.rsp"}},
+		{GCCCompiler, []string{"-c", "a.c"}, []string{}},
+	}
+	for _, tc := range cases {
+		c := NewCompiler(tc.compiler)
+		c.Parse(tc.args)
+		if files := GetResponseFiles(c); !reflect.DeepEqual(files, tc.expected) {
+			t.Errorf("%v: expected response files ['%s'], got ['%s']", tc.args, strings.Join(tc.expected, "', '"), strings.Join(files, "', '"))
+		}
+		if inputs := GetInputs(c); len(tc.expected) > 0 && !utils.Contains(inputs, tc.expected[0]) {
+			t.Errorf("%v: expected the response file in the inputs, got ['%s']", tc.args, strings.Join(inputs, "', '"))
+		}
+	}
+}
+
+func TestResponseFileChroot(t *testing.T) {
+	writeTree(t, map[string]string{
+		"root/args.rsp":   "/c /I inc @nested.rsp",
+		"root/nested.rsp": "\"src dir\\a.cpp\" /Fo:a.obj",
+	})
+	cwd, _ := os.Getwd()
+
+	msvc := NewCompiler(MSVCCompiler)
+	msvc.SetWorkingDirectory("root")
+	msvc.Parse([]string{"@args.rsp", "/nologo"})
+	msvc.Chroot("/ws")
+
+	expected := []string{"@/ws/args.rsp", "/nologo"}
+	if command := GetCommand(msvc); !reflect.DeepEqual(command, expected) {
+		t.Errorf("Expected command ['%s'], got ['%s']", strings.Join(expected, "', '"), strings.Join(command, "', '"))
+	}
+
+	msvc.Chroot(cwd + "/root")
+	if err := msvc.WriteResponseFiles(); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"root/args.rsp":   "/c\n/I\n" + cwd + "/root/inc\n@" + cwd + "/root/nested.rsp\n",
+		"root/nested.rsp": "\"" + cwd + "/root/src dir\\a.cpp\"\n/Fo:" + cwd + "/root/a.obj\n",
+	}
+	for path, content := range files {
+		written, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(written) != content {
+			t.Errorf("Expected %s to be '%s', got '%s'", path, content, written)
+		}
+	}
+}